@@ -0,0 +1,351 @@
+package logger
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/uptrace/bun"
+)
+
+// Dialect identifies the SQL dialect a BunStore talks to. Each dialect needs
+// slightly different upsert syntax for meta_keys, which is the
+// only place BunStore can't rely on bun's dialect-agnostic query builder.
+type Dialect string
+
+const (
+	DialectPostgres Dialect = "postgres"
+	DialectMySQL    Dialect = "mysql"
+	DialectSQLite   Dialect = "sqlite"
+)
+
+// BunStore is a Store implementation built on top of github.com/uptrace/bun.
+// Unlike SQLiteStore it isn't tied to a single database: it can target
+// Postgres or MySQL (in addition to sqlite) so that plunger can write into a
+// shared, centralized logging database instead of a local file.
+//
+// Schema creation is driven entirely by the `bun` struct tags on
+// LogEntry/LogEntryMeta/MetaKey (see schema.go) via bun's CreateTable, so
+// there is no hand-maintained CREATE TABLE string to keep in sync per
+// dialect.
+type BunStore struct {
+	db      *bun.DB
+	dialect Dialect
+	schema  *Schema
+}
+
+var _ Store = (*BunStore)(nil)
+
+func NewBunStore(db *bun.DB, dialect Dialect, schema *Schema) *BunStore {
+	if schema == nil {
+		schema = NewSchema()
+	}
+	return &BunStore{
+		db:      db,
+		dialect: dialect,
+		schema:  schema,
+	}
+}
+
+func (b *BunStore) Schema() *Schema {
+	return b.schema
+}
+
+func (b *BunStore) Close() error {
+	if b.db != nil {
+		return b.db.Close()
+	}
+	return nil
+}
+
+func (b *BunStore) Init() error {
+	ctx := context.Background()
+
+	models := []interface{}{
+		(*LogEntry)(nil),
+		(*LogEntryMeta)(nil),
+		(*MetaKey)(nil),
+	}
+	for _, model := range models {
+		if _, err := b.db.NewCreateTable().Model(model).IfNotExists().Exec(ctx); err != nil {
+			return err
+		}
+	}
+
+	if _, err := b.db.NewCreateIndex().
+		Model((*LogEntryMeta)(nil)).
+		Index("log_entries_meta_log_entry_id_idx").
+		IfNotExists().
+		Column("log_entry_id").
+		Exec(ctx); err != nil {
+		return err
+	}
+	if _, err := b.db.NewCreateIndex().
+		Model((*LogEntryMeta)(nil)).
+		Index("log_entries_meta_name_idx").
+		IfNotExists().
+		Column("name").
+		Exec(ctx); err != nil {
+		return err
+	}
+	if _, err := b.db.NewCreateIndex().
+		Model((*MetaKey)(nil)).
+		Index("meta_keys_key_idx").
+		IfNotExists().
+		Unique().
+		Column("key").
+		Exec(ctx); err != nil {
+		return err
+	}
+
+	if err := b.SaveMetaKeys(b.schema.MetaKeys); err != nil {
+		return err
+	}
+
+	keys, err := b.LoadMetaKeys()
+	if err != nil {
+		return err
+	}
+	b.schema.MetaKeys = keys
+
+	return nil
+}
+
+// WriteEntry always stores meta via log_entries_meta rows: BunStore doesn't
+// yet act on schema.PromotedColumns the way SQLiteStore does.
+func (b *BunStore) WriteEntry(level string, session *string, meta map[string]interface{}) error {
+	ctx := context.Background()
+
+	return b.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		entry := &LogEntry{
+			Level:   level,
+			Session: session,
+		}
+		if _, err := tx.NewInsert().Model(entry).Exec(ctx); err != nil {
+			return err
+		}
+
+		for k, v := range meta {
+			lem := &LogEntryMeta{
+				LogEntryID: entry.ID,
+			}
+
+			if metaKey, ok := b.schema.MetaKeys.Get(k); ok {
+				id := metaKey.ID
+				lem.MetaKeyID = &id
+			} else {
+				name := k
+				lem.Name = &name
+			}
+
+			switch v := v.(type) {
+			case float64:
+				lem.Type = LogEntryTypeReal
+				lem.RealValue = &v
+			case []byte:
+				lem.Type = LogEntryTypeBlob
+				lem.BlobValue = &v
+			case string:
+				lem.Type = LogEntryTypeText
+				lem.TextValue = &v
+			default:
+				b, err := json.Marshal(v)
+				if err != nil {
+					return err
+				}
+				lem.Type = LogEntryTypeJSON
+				lem.BlobValue = &b
+			}
+
+			if _, err := tx.NewInsert().Model(lem).Exec(ctx); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// errBunFilterUnsupported is returned by GetEntries when filter uses a
+// feature BunStore can't yet translate into SQL for its dialects.
+var errBunFilterUnsupported = errors.New("logger: BunStore.GetEntries does not support MetaPredicate or SelectedMetaKeys filters yet")
+
+func (b *BunStore) GetEntries(filter *GetEntriesFilter) ([]*LogEntry, error) {
+	if filter == nil {
+		filter = NewGetEntriesFilter()
+	}
+
+	// GetEntriesFilter.Apply (and its MetaPredicate tree) compiles to
+	// sqlbuilder SQL against SQLiteStore's schema (log_entries_meta,
+	// promoted columns, json_extract, FTS5). BunStore doesn't have an
+	// equivalent compiler for its dialects, so rather than silently
+	// dropping these fields and returning unfiltered rows, fail loudly.
+	if filter.MetaPredicate != nil || len(filter.SelectedMetaKeys) > 0 {
+		return nil, errBunFilterUnsupported
+	}
+
+	ctx := context.Background()
+
+	var rawEntries []*LogEntry
+	q := b.db.NewSelect().Model(&rawEntries)
+	if filter.Level != "" {
+		q = q.Where("level = ?", filter.Level)
+	}
+	if filter.Session != "" {
+		q = q.Where("session = ?", filter.Session)
+	}
+	if !filter.From.IsZero() {
+		q = q.Where("date >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		q = q.Where("date <= ?", filter.To)
+	}
+	if filter.MinID > 0 {
+		q = q.Where("id > ?", filter.MinID)
+	}
+
+	order := filter.Order
+	if order == "" {
+		order = OrderAsc
+	}
+	if filter.Cursor != "" {
+		if date, id, err := DecodeCursor(filter.Cursor); err == nil {
+			if order == OrderDesc {
+				q = q.Where("(date < ?) OR (date = ? AND id < ?)", date, date, id)
+			} else {
+				q = q.Where("(date > ?) OR (date = ? AND id > ?)", date, date, id)
+			}
+		}
+	}
+	if order == OrderDesc {
+		q = q.Order("date DESC", "id DESC")
+	} else {
+		q = q.Order("date ASC", "id ASC")
+	}
+	if filter.Limit > 0 {
+		q = q.Limit(filter.Limit)
+	}
+
+	if err := q.Scan(ctx); err != nil {
+		return nil, err
+	}
+
+	entries := map[int]*LogEntry{}
+	ids := make([]int, 0, len(rawEntries))
+	for _, entry := range rawEntries {
+		entries[entry.ID] = entry
+		ids = append(ids, entry.ID)
+	}
+
+	var metas []*LogEntryMeta
+	if len(ids) > 0 {
+		err := b.db.NewSelect().
+			Model(&metas).
+			Where("log_entry_id IN (?)", bun.In(ids)).
+			Scan(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, meta := range metas {
+		entry, ok := entries[meta.LogEntryID]
+		if !ok {
+			continue
+		}
+		if meta.Name == nil && meta.MetaKeyID != nil {
+			if metaKey, ok := b.schema.MetaKeys.GetByID(*meta.MetaKeyID); ok {
+				name := metaKey.Name
+				meta.MetaKey = &name
+			}
+		}
+
+		v, err := meta.Value()
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			continue
+		}
+		name := ""
+		if meta.Name != nil {
+			name = *meta.Name
+		} else if meta.MetaKey != nil {
+			name = *meta.MetaKey
+		} else {
+			continue
+		}
+		if entry.Meta == nil {
+			entry.Meta = map[string]interface{}{}
+		}
+		entry.Meta[name] = v
+	}
+
+	ret := make([]*LogEntry, 0, len(entries))
+	for _, entry := range entries {
+		ret = append(ret, entry)
+	}
+	if order == OrderDesc {
+		sort.Slice(ret, func(i, j int) bool {
+			return ret[i].ID > ret[j].ID
+		})
+	} else {
+		sort.Slice(ret, func(i, j int) bool {
+			return ret[i].ID < ret[j].ID
+		})
+	}
+
+	return ret, nil
+}
+
+// SaveMetaKeys upserts the meta key registry, using the dialect-appropriate
+// upsert: `ON CONFLICT ... DO UPDATE` for Postgres/SQLite and
+// `ON DUPLICATE KEY UPDATE` for MySQL.
+func (b *BunStore) SaveMetaKeys(keys *MetaKeys) error {
+	if len(keys.Keys) == 0 {
+		return nil
+	}
+
+	ctx := context.Background()
+	values := make([]*MetaKey, 0, len(keys.Keys))
+	for _, v := range keys.Keys {
+		values = append(values, v)
+	}
+
+	q := b.db.NewInsert().Model(&values)
+	switch b.dialect {
+	case DialectMySQL:
+		q = q.On("DUPLICATE KEY UPDATE key = VALUES(key)")
+	case DialectPostgres, DialectSQLite:
+		q = q.On("CONFLICT (id) DO UPDATE").Set("key = EXCLUDED.key")
+	default:
+		return fmt.Errorf("unsupported dialect %q", b.dialect)
+	}
+
+	_, err := q.Exec(ctx)
+	return err
+}
+
+func (b *BunStore) LoadMetaKeys() (*MetaKeys, error) {
+	ctx := context.Background()
+	keys := NewMetaKeys()
+
+	var rows []*MetaKey
+	if err := b.db.NewSelect().Model(&rows).Scan(ctx); err != nil {
+		if err == sql.ErrNoRows {
+			return keys, nil
+		}
+		return nil, err
+	}
+
+	for _, row := range rows {
+		if _, err := keys.AddWithID(row.Name, row.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,17 @@
+package logger
+
+import "testing"
+
+func TestBunStore_GetEntries_RejectsUnsupportedFilters(t *testing.T) {
+	store := NewBunStore(nil, DialectSQLite, nil)
+
+	_, err := store.GetEntries(NewGetEntriesFilter(WithMetaPredicate(Eq("k", "v"))))
+	if err == nil {
+		t.Fatalf("expected GetEntries to reject a MetaPredicate filter instead of silently ignoring it")
+	}
+
+	_, err = store.GetEntries(NewGetEntriesFilter(WithSelectedMetaKeys("k")))
+	if err == nil {
+		t.Fatalf("expected GetEntries to reject a SelectedMetaKeys filter instead of silently ignoring it")
+	}
+}
@@ -1,9 +1,33 @@
 package logger
 
 import (
+	"encoding/base64"
 	"fmt"
-	"github.com/huandu/go-sqlbuilder"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/pkg/errors"
+)
+
+// dateLayout is the text format log_entries.date is written and compared in
+// everywhere it's treated as a sortable string: WriteEntry/WriteEntries
+// (sqlite_store.go), scanLogEntryRow, and the From/To/cursor bounds below.
+// Unlike time.RFC3339Nano, it always emits a fixed 9-digit fractional
+// component instead of trimming trailing zeros, so plain string comparison
+// agrees with chronological order at every timestamp - RFC3339Nano's
+// trimming makes an exact-second timestamp ("...05Z") sort *after* any
+// timestamp in the same second with a nonzero fractional part ("...05.1Z"),
+// since '.' (0x2E) sorts before 'Z' (0x5A).
+const dateLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// Order controls the sort direction GetEntries/StreamEntries return rows in.
+type Order string
+
+const (
+	OrderAsc  Order = "asc"
+	OrderDesc Order = "desc"
 )
 
 type GetEntriesFilter struct {
@@ -12,7 +36,19 @@ type GetEntriesFilter struct {
 	From             time.Time
 	To               time.Time
 	SelectedMetaKeys []string
-	MetaFilters      map[string]interface{}
+	MetaPredicate    *MetaPredicate
+
+	// Limit caps the number of rows returned. Used as the page size by
+	// StreamEntries.
+	Limit int
+	// Cursor is an opaque token, produced by EncodeCursor, that resumes a
+	// keyset-paginated query after the (date, id) it encodes.
+	Cursor string
+	// Order controls sort direction; defaults to OrderAsc.
+	Order Order
+	// MinID restricts results to rows with id greater than MinID, which is
+	// how Tail polls for newly written entries.
+	MinID int
 }
 
 type GetEntriesFilterOption func(*GetEntriesFilter)
@@ -50,17 +86,42 @@ func WithSelectedMetaKeys(keys ...string) GetEntriesFilterOption {
 	}
 }
 
-func WithMetaFilters(filters map[string]interface{}) GetEntriesFilterOption {
+// WithMetaPredicate sets the predicate tree used to filter on meta fields;
+// see MetaPredicate and its constructors (Eq, In, JSONPath, FullText, ...).
+func WithMetaPredicate(p *MetaPredicate) GetEntriesFilterOption {
 	return func(f *GetEntriesFilter) {
-		if f.MetaFilters == nil {
-			f.MetaFilters = map[string]interface{}{}
-		}
-		for k, v := range filters {
-			f.MetaFilters[k] = v
+		if f.MetaPredicate == nil {
+			f.MetaPredicate = p
+		} else {
+			f.MetaPredicate = And(f.MetaPredicate, p)
 		}
 	}
 }
 
+func WithLimit(limit int) GetEntriesFilterOption {
+	return func(f *GetEntriesFilter) {
+		f.Limit = limit
+	}
+}
+
+func WithCursor(cursor string) GetEntriesFilterOption {
+	return func(f *GetEntriesFilter) {
+		f.Cursor = cursor
+	}
+}
+
+func WithOrder(order Order) GetEntriesFilterOption {
+	return func(f *GetEntriesFilter) {
+		f.Order = order
+	}
+}
+
+func WithMinID(id int) GetEntriesFilterOption {
+	return func(f *GetEntriesFilter) {
+		f.MinID = id
+	}
+}
+
 func NewGetEntriesFilter(opts ...GetEntriesFilterOption) *GetEntriesFilter {
 	f := &GetEntriesFilter{}
 	for _, opt := range opts {
@@ -69,7 +130,35 @@ func NewGetEntriesFilter(opts ...GetEntriesFilterOption) *GetEntriesFilter {
 	return f
 }
 
-func (gef *GetEntriesFilter) Apply(metaKeys *MetaKeys, q *sqlbuilder.SelectBuilder) {
+// EncodeCursor builds an opaque keyset pagination cursor from the (date, id)
+// of the last row seen on the previous page.
+func EncodeCursor(date time.Time, id int) string {
+	raw := fmt.Sprintf("%d|%d", date.UnixNano(), id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor reverses EncodeCursor.
+func DecodeCursor(cursor string) (time.Time, int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, errors.Wrap(err, "invalid cursor")
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return time.Time{}, 0, errors.New("invalid cursor")
+	}
+	ns, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, errors.Wrap(err, "invalid cursor")
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return time.Time{}, 0, errors.Wrap(err, "invalid cursor")
+	}
+	return time.Unix(0, ns).UTC(), id, nil
+}
+
+func (gef *GetEntriesFilter) Apply(schema *Schema, q *sqlbuilder.SelectBuilder) {
 	if gef.Level != "" {
 		q.Where(q.E("level", gef.Level))
 	}
@@ -77,45 +166,67 @@ func (gef *GetEntriesFilter) Apply(metaKeys *MetaKeys, q *sqlbuilder.SelectBuild
 		q.Where(q.E("session", gef.Session))
 	}
 	if !gef.From.IsZero() {
-		q.Where(q.GE("date", gef.From.Format(time.RFC3339)))
+		q.Where(q.GE("date", gef.From.Format(dateLayout)))
 	}
 	if !gef.To.IsZero() {
-		q.Where(q.LE("date", gef.To.Format(time.RFC3339)))
+		q.Where(q.LE("date", gef.To.Format(dateLayout)))
 	}
 	if len(gef.SelectedMetaKeys) > 0 {
-		stringKeys := []string{}
-		intKeys := []int{}
+		// Matches the correlated-EXISTS pattern MetaPredicate.Apply uses
+		// (predicate.go): log_entries is never joined to meta_keys/
+		// log_entries_meta directly, so each selected key needs its own
+		// subquery rather than a bare "mk.name IN (...)" against columns
+		// that aren't in scope here.
+		exprs := make([]string, 0, len(gef.SelectedMetaKeys))
 		for _, k := range gef.SelectedMetaKeys {
-			v, ok := metaKeys.Get(k)
-			if !ok {
-				stringKeys = append(stringKeys, k)
-			} else {
-				intKeys = append(intKeys, v.ID)
-			}
-		}
-		exprs := []string{}
-		for _, k := range stringKeys {
-			exprs = append(exprs, q.In("mk.name", k))
+			keyExpr := fmt.Sprintf("(mk.key = %s OR lem.name = %s)", q.Var(k), q.Var(k))
+			exprs = append(exprs, fmt.Sprintf(
+				"EXISTS (SELECT 1 FROM log_entries_meta lem LEFT JOIN meta_keys mk ON mk.id = lem.meta_key_id "+
+					"WHERE lem.log_entry_id = log_entries.id AND %s)", keyExpr,
+			))
 		}
-		for _, k := range intKeys {
-			exprs = append(exprs, q.In("mk.meta_key_id", k))
-		}
-		if len(exprs) > 0 {
-			q.Where(q.Or(exprs...))
+		q.Where(q.Or(exprs...))
+	}
+
+	if gef.MetaPredicate != nil {
+		if expr := gef.MetaPredicate.Apply(schema, q); expr != "" {
+			q.Where(expr)
 		}
 	}
 
-	if len(gef.MetaFilters) > 0 {
-		for k, v := range gef.MetaFilters {
-			v_, ok := metaKeys.Get(k)
-			entryType := ToLogEntryType(v)
-			fieldName := entryType.String() + "_value"
-			exprs := []string{}
-			exprs = append(exprs, q.And(q.E("mk.name", k), q.E(fmt.Sprintf("lem.%s", fieldName), v)))
-			if ok {
-				exprs = append(exprs, q.And(q.E("mk.meta_key_id", v_.ID), q.E(fmt.Sprintf("lem.%s", fieldName), v)))
+	if gef.MinID > 0 {
+		q.Where(q.G("id", gef.MinID))
+	}
+
+	order := gef.Order
+	if order == "" {
+		order = OrderAsc
+	}
+
+	if gef.Cursor != "" {
+		if date, id, err := DecodeCursor(gef.Cursor); err == nil {
+			ts := date.Format(dateLayout)
+			if order == OrderDesc {
+				q.Where(q.Or(
+					q.L("date", ts),
+					q.And(q.E("date", ts), q.L("id", id)),
+				))
+			} else {
+				q.Where(q.Or(
+					q.G("date", ts),
+					q.And(q.E("date", ts), q.G("id", id)),
+				))
 			}
-			q.Where(q.Or(exprs...))
 		}
 	}
+
+	if order == OrderDesc {
+		q.OrderBy("date DESC, id DESC")
+	} else {
+		q.OrderBy("date ASC, id ASC")
+	}
+
+	if gef.Limit > 0 {
+		q.Limit(gef.Limit)
+	}
 }
@@ -0,0 +1,48 @@
+package logger
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetEntriesFilter_FromMatchesSameSecondWrites(t *testing.T) {
+	store := newTestSQLiteStore(t)
+
+	from := time.Now().UTC().Truncate(time.Second)
+
+	if err := store.WriteEntry("info", nil, nil); err != nil {
+		t.Fatalf("WriteEntry: %v", err)
+	}
+
+	entries, err := store.GetEntries(NewGetEntriesFilter(WithFrom(from)))
+	if err != nil {
+		t.Fatalf("GetEntries: %v", err)
+	}
+
+	// date is stored with sub-second precision (dateLayout); From must be
+	// formatted the same way, or an entry written later in the same second
+	// as From sorts lexicographically *before* it and is wrongly excluded.
+	if len(entries) != 1 {
+		t.Fatalf("expected the entry written in the same second as From to match, got %d entries", len(entries))
+	}
+}
+
+func TestGetEntriesFilter_SelectedMetaKeysMatchesAgainstMeta(t *testing.T) {
+	store := newTestSQLiteStore(t)
+
+	if err := store.WriteEntry("info", nil, map[string]interface{}{"user_id": "u1"}); err != nil {
+		t.Fatalf("WriteEntry: %v", err)
+	}
+	if err := store.WriteEntry("info", nil, map[string]interface{}{"other": "v"}); err != nil {
+		t.Fatalf("WriteEntry: %v", err)
+	}
+
+	entries, err := store.GetEntries(NewGetEntriesFilter(WithSelectedMetaKeys("user_id")))
+	if err != nil {
+		t.Fatalf("GetEntries: %v", err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("expected only the entry carrying the selected meta key, got %d entries", len(entries))
+	}
+}
@@ -1,394 +1,353 @@
 package logger
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
-	"fmt"
-	"github.com/huandu/go-sqlbuilder"
-	"github.com/jmoiron/sqlx"
-	_ "github.com/mattn/go-sqlite3"
 	"io"
-	"sort"
-	"strings"
+	"sync"
 	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // LogWriter is the main class in Plunger.
 //
 // It deserializes the JSON binaries handed over by zerolog, and decomposes
-// the message into the database schema specified at creation time.
+// the message into the database schema specified at creation time. The
+// actual persistence (DDL, upserts, queries) is delegated to a Store, so
+// LogWriter itself stays backend-agnostic; see SQLiteStore and BunStore for
+// the current implementations.
+//
+// By default, Write persists each line synchronously. Passing WithQueueSize
+// switches LogWriter into buffered mode: Write enqueues the parsed entry and
+// returns immediately, while a background goroutine flushes batches of
+// entries, using Store's BatchWriter implementation when available.
+// WithBatchSize and WithFlushInterval only tune buffered mode; on their own
+// they leave Write synchronous.
 type LogWriter struct {
-	db *sqlx.DB
+	store Store
+
+	batchSize     int
+	flushInterval time.Duration
+	queueSize     int
+	dropOldest    bool
+
+	queue   chan ParsedEntry
+	flushed chan chan error
+	stop    chan struct{}
+	stopErr chan error
+	wg      sync.WaitGroup
 
-	schema *Schema
+	metrics Metrics
 }
 
 var _ io.Writer = (*LogWriter)(nil)
 
-func NewLogWriter(db *sqlx.DB, schema *Schema) *LogWriter {
-	return &LogWriter{
-		db:     db,
-		schema: schema,
-	}
+// Metrics are the Prometheus counters LogWriter updates in buffered mode.
+type Metrics struct {
+	Enqueued prometheus.Counter
+	Dropped  prometheus.Counter
+	Flushed  prometheus.Counter
+	Failed   prometheus.Counter
 }
 
-func (l *LogWriter) Close() error {
-	if l.db != nil {
-		return l.db.Close()
-	} else {
-		return nil
+func defaultMetrics() Metrics {
+	return Metrics{
+		Enqueued: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "plunger", Subsystem: "log_writer", Name: "enqueued_total",
+			Help: "Number of log entries enqueued for buffered writing.",
+		}),
+		Dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "plunger", Subsystem: "log_writer", Name: "dropped_total",
+			Help: "Number of log entries dropped because the queue was full.",
+		}),
+		Flushed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "plunger", Subsystem: "log_writer", Name: "flushed_total",
+			Help: "Number of log entries successfully flushed to the store.",
+		}),
+		Failed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "plunger", Subsystem: "log_writer", Name: "flush_failed_total",
+			Help: "Number of batch flushes to the store that returned an error.",
+		}),
 	}
 }
 
-func (l *LogWriter) Write(p []byte) (int, error) {
-	var log map[string]interface{}
-	if err := json.Unmarshal(p, &log); err != nil {
-		return 0, err
-	}
+type LogWriterOption func(*LogWriter)
 
-	tx, err := l.db.Beginx()
-	if err != nil {
-		return 0, err
+// WithBatchSize sets how many queued entries are flushed together in a
+// single Store write. Defaults to 1.
+func WithBatchSize(n int) LogWriterOption {
+	return func(l *LogWriter) {
+		l.batchSize = n
 	}
-	defer func() {
-		if err != nil {
-			err = tx.Rollback()
-			return
-		}
-		err = tx.Commit()
-	}()
-
-	// Insert the log entry
-	logEntryID := 0
-	q := sqlbuilder.NewInsertBuilder()
-	q.InsertInto("log_entries").
-		Cols("date", "level", "session").
-		Values(time.Now().UTC(), log["level"], log["session"]).
-		SQL("RETURNING id")
-	s, args := q.Build()
-	if err := tx.QueryRowx(s, args...).Scan(&logEntryID); err != nil {
-		return 0, err
-	}
-
-	// Serialize the log data as log entries meta
-	for k, v := range log {
-		if k == "level" || k == "session" {
-			continue
-		}
-
-		var intValue sql.NullInt64
-		var realValue sql.NullFloat64
-		var textValue, blobValue sql.NullString
-		var typeValue LogEntryType
-		var name sql.NullString
-		var meta_key_id sql.NullInt32
-
-		switch v := v.(type) {
-		case float64:
-			realValue = sql.NullFloat64{Float64: v, Valid: true}
-			typeValue = LogEntryTypeReal
-		case []byte:
-			blobValue = sql.NullString{String: string(v), Valid: true}
-			typeValue = LogEntryTypeBlob
-		case string:
-			textValue = sql.NullString{String: v, Valid: true}
-			typeValue = LogEntryTypeText
-		default:
-			b, err := json.Marshal(v)
-			if err != nil {
-				return 0, err
-			}
-			blobValue = sql.NullString{String: string(b), Valid: true}
-			typeValue = LogEntryTypeJSON
-		}
-
-		// NOTE(manuel, 2023-10-22) Honestly this is all preemptive optimization, I actually don't know if this is necessary.
-		// Maybe the app using the logger could instead just give which columns should be used.
-
-		// If we have a metakey for this key, use its id for storage.
-		if metaKey, ok := l.schema.MetaKeys.Get(k); ok {
-			meta_key_id = sql.NullInt32{Int32: int32(metaKey.ID), Valid: true}
-		} else {
-			name = sql.NullString{String: k, Valid: true}
-		}
+}
 
-		q := sqlbuilder.NewInsertBuilder()
-		// NOTE(manuel, 2023-10-22) We could probably collect the values and do only a single insert with all the values at once
-		q.InsertInto("log_entries_meta").
-			Cols("log_entry_id", "type", "name", "meta_key_id", "int_value", "real_value", "text_value", "blob_value").
-			Values(logEntryID, typeValue, name, meta_key_id, intValue, realValue, textValue, blobValue)
-		s, args := q.Build()
-		if _, err := tx.Exec(s, args...); err != nil {
-			return 0, err
-		}
+// WithFlushInterval sets the maximum time a partial batch waits before
+// being flushed. Defaults to 1s.
+func WithFlushInterval(d time.Duration) LogWriterOption {
+	return func(l *LogWriter) {
+		l.flushInterval = d
 	}
-
-	return len(p), nil
 }
 
-func (l *LogWriter) GetEntries(filter *GetEntriesFilter) ([]*LogEntry, error) {
-	if filter == nil {
-		filter = NewGetEntriesFilter()
+// WithQueueSize puts LogWriter into buffered mode, with a queue that holds
+// up to n entries. Without this option, Write persists synchronously.
+func WithQueueSize(n int) LogWriterOption {
+	return func(l *LogWriter) {
+		l.queueSize = n
 	}
+}
 
-	entries := map[int]*LogEntry{}
-	q := sqlbuilder.Select("*").From("log_entries").OrderBy("id ASC")
-	filter.Apply(l.schema.MetaKeys, q)
-	s2, args := q.Build()
-	s2 = l.db.Rebind(s2)
-	rows, err := l.db.Queryx(s2, args...)
-	if err != nil {
-		return nil, err
+// WithDropOldest makes Write drop the oldest queued entry to make room for
+// a new one when the queue is full, instead of blocking the caller. Only
+// has an effect in buffered mode.
+func WithDropOldest() LogWriterOption {
+	return func(l *LogWriter) {
+		l.dropOldest = true
 	}
-	defer func(rows *sqlx.Rows) {
-		_ = rows.Close()
-	}(rows)
-
-	ids := []interface{}{}
+}
 
-	for rows.Next() {
-		entry := &LogEntry{}
-		if err := rows.StructScan(entry); err != nil {
-			return nil, err
-		}
-		entries[entry.ID] = entry
-		ids = append(ids, entry.ID)
+// WithMetrics overrides the Prometheus counters used in buffered mode, e.g.
+// to register them under a caller-owned registry.
+func WithMetrics(m Metrics) LogWriterOption {
+	return func(l *LogWriter) {
+		l.metrics = m
 	}
+}
 
-	sb := sqlbuilder.Select("lem.*, mk.key AS meta_key").
-		From("log_entries_meta lem")
-
-	sb = sb.Where(sb.In("lem.log_entry_id", ids...)).
-		JoinWithOption(sqlbuilder.LeftJoin, "meta_keys mk", "mk.id = lem.meta_key_id")
-
-	s, args := sb.Build()
-	s = l.db.Rebind(s)
-	rows, err = l.db.Queryx(s, args...)
-	if err != nil {
-		return nil, err
+func NewLogWriter(store Store, opts ...LogWriterOption) *LogWriter {
+	l := &LogWriter{
+		store:         store,
+		batchSize:     1,
+		flushInterval: time.Second,
+		metrics:       defaultMetrics(),
 	}
-	defer func(rows *sqlx.Rows) {
-		_ = rows.Close()
-	}(rows)
-
-	for rows.Next() {
-		meta := &LogEntryMeta{}
-		if err := rows.StructScan(meta); err != nil {
-			return nil, err
-		}
-		entry, ok := entries[meta.LogEntryID]
-		if !ok {
-			continue
-		}
-
-		if entry.Meta == nil {
-			entry.Meta = map[string]interface{}{}
-		}
-		v, err := meta.Value()
-		if err != nil {
-			return nil, err
-		}
-		if v == nil {
-			continue
-		}
-		name := ""
-		if meta.Name != nil {
-			name = *meta.Name
-		} else if meta.MetaKey != nil {
-			name = *meta.MetaKey
-		} else {
-			continue
-		}
-		entry.Meta[name] = v
+	for _, opt := range opts {
+		opt(l)
 	}
 
-	ret := []*LogEntry{}
-	for _, entry := range entries {
-		ret = append(ret, entry)
+	if l.queueSize > 0 {
+		l.queue = make(chan ParsedEntry, l.queueSize)
+		l.flushed = make(chan chan error)
+		l.stop = make(chan struct{})
+		l.stopErr = make(chan error, 1)
+		l.wg.Add(1)
+		go l.flushLoop()
 	}
 
-	// sort by id
-	sort.Slice(ret, func(i, j int) bool {
-		return ret[i].ID < ret[j].ID
-	})
+	return l
+}
 
-	return ret, nil
+func (l *LogWriter) buffered() bool {
+	return l.queue != nil
 }
 
-func (l *LogWriter) Init() error {
-	ctb := sqlbuilder.NewCreateTableBuilder()
-	ctb.CreateTable("log_entries").
-		IfNotExists().
-		Define("id", "INTEGER", "PRIMARY KEY", "AUTOINCREMENT").
-		Define("date", "TIMESTAMP", "NOT NULL").
-		Define("level", "VARCHAR(255)", "NOT NULL").
-		Define("session", "VARCHAR(255)")
-	if _, err := l.db.Exec(ctb.String()); err != nil {
-		return err
+func (l *LogWriter) Close() error {
+	var flushErr error
+	if l.buffered() {
+		close(l.stop)
+		l.wg.Wait()
+		flushErr = <-l.stopErr
 	}
+	closeErr := l.store.Close()
+
+	switch {
+	case flushErr != nil && closeErr != nil:
+		return errors.Wrapf(closeErr, "store close failed after final flush also failed (%s)", flushErr)
+	case flushErr != nil:
+		return errors.Wrap(flushErr, "final flush before close failed")
+	default:
+		return closeErr
+	}
+}
 
-	ctb = sqlbuilder.NewCreateTableBuilder()
-	ctb.CreateTable("log_entries_meta").
-		IfNotExists().
-		Define("id", "INTEGER", "PRIMARY KEY", "AUTOINCREMENT").
-		Define("log_entry_id", "INTEGER", "NOT NULL").
-		Define("type", "INTEGER", "NOT NULL").
-		Define("meta_key_id", "INTEGER").
-		Define("name", "VARCHAR(255)").
-		Define("int_value", "INTEGER").
-		Define("real_value", "REAL").
-		Define("text_value", "TEXT").
-		Define("blob_value", "BLOB")
-
-	if _, err := l.db.Exec(ctb.String()); err != nil {
-		return err
+func (l *LogWriter) Init() error {
+	return l.store.Init()
+}
+
+func (l *LogWriter) Write(p []byte) (int, error) {
+	var log map[string]interface{}
+	if err := json.Unmarshal(p, &log); err != nil {
+		return 0, err
 	}
 
-	// create indices using raw sql
-	indexedColumns := []string{
-		"log_entry_id",
-		"type",
-		"name",
+	level, _ := log["level"].(string)
+	var session *string
+	if s, ok := log["session"].(string); ok {
+		session = &s
 	}
-	for _, col := range indexedColumns {
-		query := fmt.Sprintf("CREATE INDEX IF NOT EXISTS log_entries_meta_%s_idx ON log_entries_meta (%s)", col, col)
-		_, err := l.db.Exec(query)
-		if err != nil {
-			return err
+
+	meta := make(map[string]interface{}, len(log))
+	for k, v := range log {
+		if k == "level" || k == "session" {
+			continue
 		}
+		meta[k] = v
 	}
 
-	ctb = sqlbuilder.NewCreateTableBuilder()
-	ctb.CreateTable("meta_keys").
-		IfNotExists().
-		Define("id", "INTEGER", "PRIMARY KEY NOT NULL").
-		Define("key", "VARCHAR(255)")
-	if _, err := l.db.Exec(ctb.String()); err != nil {
-		return err
-	}
+	entry := ParsedEntry{Level: level, Session: session, Meta: meta}
 
-	// add unique index on key
-	_, err := l.db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS meta_keys_key_idx ON meta_keys (key)")
-	if err != nil {
-		return err
+	if !l.buffered() {
+		if err := l.store.WriteEntry(entry.Level, entry.Session, entry.Meta); err != nil {
+			return 0, err
+		}
+		return len(p), nil
 	}
 
-	err = l.saveSchema()
-	if err != nil {
-		return err
-	}
+	l.enqueue(entry)
+	return len(p), nil
+}
 
-	err = l.createTypeEnumTable()
-	if err != nil {
-		return err
+// enqueue adds entry to the buffered queue, applying the configured
+// block-on-full (default) or drop-oldest policy.
+func (l *LogWriter) enqueue(entry ParsedEntry) {
+	select {
+	case l.queue <- entry:
+		l.metrics.Enqueued.Inc()
+		return
+	default:
 	}
 
-	err = l.loadSchema()
-	if err != nil {
-		return err
+	if !l.dropOldest {
+		l.queue <- entry
+		l.metrics.Enqueued.Inc()
+		return
 	}
 
-	return nil
+	select {
+	case <-l.queue:
+		l.metrics.Dropped.Inc()
+	default:
+	}
+	select {
+	case l.queue <- entry:
+		l.metrics.Enqueued.Inc()
+	default:
+		l.metrics.Dropped.Inc()
+	}
 }
 
-// TODO(manuel, 2023-08-19) Add a function to upgrade previously non-meta keys to a meta key
+// Flush blocks until every entry queued so far has been persisted. Calling
+// Flush after Close returns an error instead of deadlocking, and so does a
+// Flush racing Close unless flushLoop picks up its request first, in which
+// case it returns that flush's result: the send to l.flushed races against
+// l.stop being closed (directly, or via flushLoop exiting after observing
+// it), and a select only ever blocks on the send while a receiver could
+// still appear.
+func (l *LogWriter) Flush() error {
+	if !l.buffered() {
+		return nil
+	}
+	ack := make(chan error, 1)
+	select {
+	case l.flushed <- ack:
+	case <-l.stop:
+		return errors.New("logger: Flush called after Close")
+	}
+	return <-ack
+}
 
-// TODO(manuel, 2023-08-19) Add a function to add column names straight to the log entries table
+// flushLoop is the background goroutine driving buffered mode: it batches
+// queued entries up to batchSize or flushInterval, whichever comes first.
+func (l *LogWriter) flushLoop() {
+	defer l.wg.Done()
 
-// saveSchema stores the schema of the logwriter in the database.
-//
-// NOTE(manuel, 2023-02-06): This is a very naive implementation.
-// It currently blindly overwrites it, but in the future, it will warn
-// if there is a schema mismatch with what is already present.
-func (l *LogWriter) saveSchema() error {
-	err := l.saveMetaKeys()
-	if err != nil {
-		return err
-	}
+	ticker := time.NewTicker(l.flushInterval)
+	defer ticker.Stop()
 
-	return nil
-}
+	batch := make([]ParsedEntry, 0, l.batchSize)
 
-func (l *LogWriter) loadSchema() error {
-	err := l.loadMetaKeys()
-	if err != nil {
+	flush := func() error {
+		err := l.flushBatch(batch)
+		batch = batch[:0]
 		return err
 	}
 
-	return nil
+	for {
+		select {
+		case entry := <-l.queue:
+			batch = append(batch, entry)
+			if len(batch) >= l.batchSize {
+				if err := flush(); err != nil {
+					l.metrics.Failed.Inc()
+				}
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				if err := flush(); err != nil {
+					l.metrics.Failed.Inc()
+				}
+			}
+		case ack := <-l.flushed:
+			l.drainQueue(&batch)
+			err := flush()
+			if err != nil {
+				l.metrics.Failed.Inc()
+			}
+			ack <- err
+		case <-l.stop:
+			l.drainQueue(&batch)
+			err := flush()
+			if err != nil {
+				l.metrics.Failed.Inc()
+			}
+			l.stopErr <- err
+			return
+		}
+	}
 }
 
-func (l *LogWriter) createTypeEnumTable() error {
-	ctb := sqlbuilder.NewCreateTableBuilder()
-	ctb.CreateTable("type_enum").
-		IfNotExists().
-		Define("type", "VARCHAR(255)", "PRIMARY KEY").
-		Define("seq", "INTEGER", "NOT NULL")
-	if _, err := l.db.Exec(ctb.String()); err != nil {
-		return err
+// drainQueue moves every entry currently sitting in the queue into batch
+// without blocking, so Flush/Close see everything enqueued up to that point.
+func (l *LogWriter) drainQueue(batch *[]ParsedEntry) {
+	for {
+		select {
+		case entry := <-l.queue:
+			*batch = append(*batch, entry)
+		default:
+			return
+		}
 	}
+}
 
-	// Insert the types using InsertBuilder
-	q := sqlbuilder.NewInsertBuilder()
-	q.InsertInto("type_enum").
-		Cols("type", "seq").
-		Values("real", LogEntryTypeReal).
-		Values("text", LogEntryTypeText).
-		Values("blob", LogEntryTypeBlob).
-		Values("json", LogEntryTypeJSON).
-		SQL("ON CONFLICT (type) DO NOTHING")
-	s, args := q.Build()
-	if _, err := l.db.Exec(s, args...); err != nil {
-		return err
+func (l *LogWriter) flushBatch(batch []ParsedEntry) error {
+	if len(batch) == 0 {
+		return nil
 	}
 
-	return nil
-}
-
-func (l *LogWriter) saveMetaKeys() error {
-	// Insert the keys using InsertBuilder
-	if len(l.schema.MetaKeys.Keys) > 0 {
-		q := sqlbuilder.NewInsertBuilder()
-		q.InsertInto("meta_keys").
-			Cols("id", "key")
-		for _, v := range l.schema.MetaKeys.Keys {
-			q.Values(v.ID, v.Name)
+	var err error
+	written := 0
+	if bw, ok := l.store.(BatchWriter); ok {
+		if err = bw.WriteEntries(batch); err == nil {
+			written = len(batch)
 		}
-		s, args := q.Build()
-		// replace INSERT with INSERT OR REPLACE
-		s = strings.Replace(s, "INSERT", "INSERT OR REPLACE", 1)
-		if _, err := l.db.Exec(s, args...); err != nil {
-			return err
+	} else {
+		for _, entry := range batch {
+			if werr := l.store.WriteEntry(entry.Level, entry.Session, entry.Meta); werr != nil {
+				err = werr
+				continue
+			}
+			written++
 		}
 	}
 
-	return nil
+	l.metrics.Flushed.Add(float64(written))
+	return err
 }
 
-func (l *LogWriter) loadMetaKeys() error {
-	l.schema.MetaKeys = NewMetaKeys()
+func (l *LogWriter) GetEntries(filter *GetEntriesFilter) ([]*LogEntry, error) {
+	return l.store.GetEntries(filter)
+}
 
-	s := sqlbuilder.Select("*").From("meta_keys")
-	rows, err := l.db.Query(s.String())
-	if err != nil {
-		return err
-	}
-	defer func(rows *sql.Rows) {
-		_ = rows.Close()
-	}(rows)
-
-	for rows.Next() {
-		var id int
-		var key string
-		err = rows.Scan(&id, &key)
-		if err != nil {
-			return err
-		}
-		_, err = l.schema.MetaKeys.AddWithID(key, id)
-		if err != nil {
-			return err
-		}
-	}
+// StreamEntries pages through the store; see the package-level StreamEntries
+// for details.
+func (l *LogWriter) StreamEntries(ctx context.Context, filter *GetEntriesFilter) (<-chan *LogEntry, <-chan error, error) {
+	return StreamEntries(ctx, l.store, filter)
+}
 
-	return nil
+// Tail follows newly written entries; see the package-level Tail for
+// details. It's what backs a `plunger logs -f`-style command.
+func (l *LogWriter) Tail(ctx context.Context, filter *GetEntriesFilter, interval time.Duration) (<-chan *LogEntry, <-chan error, error) {
+	return Tail(ctx, l.store, filter, interval)
 }
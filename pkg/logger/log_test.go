@@ -0,0 +1,139 @@
+package logger
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStore is a minimal in-memory Store used to exercise LogWriter's
+// buffered mode without a real database.
+type fakeStore struct {
+	mu       sync.Mutex
+	entries  []ParsedEntry
+	failing  bool
+	closeErr error
+}
+
+var _ Store = (*fakeStore)(nil)
+var _ BatchWriter = (*fakeStore)(nil)
+
+func (f *fakeStore) Init() error { return nil }
+
+func (f *fakeStore) WriteEntry(level string, session *string, meta map[string]interface{}) error {
+	return f.WriteEntries([]ParsedEntry{{Level: level, Session: session, Meta: meta}})
+}
+
+func (f *fakeStore) WriteEntries(entries []ParsedEntry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failing {
+		return errors.New("fakeStore: forced failure")
+	}
+	f.entries = append(f.entries, entries...)
+	return nil
+}
+
+func (f *fakeStore) GetEntries(filter *GetEntriesFilter) ([]*LogEntry, error) { return nil, nil }
+func (f *fakeStore) SaveMetaKeys(keys *MetaKeys) error                        { return nil }
+func (f *fakeStore) LoadMetaKeys() (*MetaKeys, error)                         { return NewMetaKeys(), nil }
+func (f *fakeStore) Schema() *Schema                                          { return NewSchema() }
+func (f *fakeStore) Close() error                                             { return f.closeErr }
+
+func (f *fakeStore) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.entries)
+}
+
+func TestLogWriter_BufferedFlushOnClose(t *testing.T) {
+	store := &fakeStore{}
+	l := NewLogWriter(store, WithQueueSize(10), WithBatchSize(100), WithFlushInterval(time.Hour))
+
+	if _, err := l.Write([]byte(`{"level":"info","msg":"hi"}`)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if store.count() != 1 {
+		t.Fatalf("expected Close to flush the queued entry, got %d entries", store.count())
+	}
+}
+
+func TestLogWriter_CloseReturnsFinalFlushError(t *testing.T) {
+	store := &fakeStore{failing: true}
+	l := NewLogWriter(store, WithQueueSize(10), WithBatchSize(100), WithFlushInterval(time.Hour))
+
+	if _, err := l.Write([]byte(`{"level":"info","msg":"hi"}`)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	if err := l.Close(); err == nil {
+		t.Fatalf("expected Close to surface the final flush's error")
+	}
+}
+
+func TestLogWriter_CloseReportsStoreCloseErrorEvenAfterFlushFailure(t *testing.T) {
+	store := &fakeStore{failing: true, closeErr: errors.New("fakeStore: forced close failure")}
+	l := NewLogWriter(store, WithQueueSize(10), WithBatchSize(100), WithFlushInterval(time.Hour))
+
+	if _, err := l.Write([]byte(`{"level":"info","msg":"hi"}`)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	err := l.Close()
+	if err == nil {
+		t.Fatalf("expected Close to return an error")
+	}
+	if !strings.Contains(err.Error(), "forced close failure") {
+		t.Fatalf("expected Close's error to mention the store close failure too, got: %v", err)
+	}
+}
+
+func TestLogWriter_FlushRacingCloseDoesNotDeadlock(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		store := &fakeStore{}
+		l := NewLogWriter(store, WithQueueSize(10), WithBatchSize(100), WithFlushInterval(time.Hour))
+
+		done := make(chan struct{})
+		go func() {
+			_ = l.Flush()
+			close(done)
+		}()
+
+		if err := l.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Flush racing Close deadlocked")
+		}
+	}
+}
+
+func TestLogWriter_FlushAfterCloseDoesNotDeadlock(t *testing.T) {
+	store := &fakeStore{}
+	l := NewLogWriter(store, WithQueueSize(10), WithBatchSize(100), WithFlushInterval(time.Hour))
+
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- l.Flush() }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatalf("expected Flush after Close to return an error, not block or succeed silently")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Flush after Close deadlocked")
+	}
+}
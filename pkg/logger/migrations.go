@@ -0,0 +1,300 @@
+package logger
+
+import (
+	"database/sql"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// currentDatabaseVersion is the schema version SQLiteStore expects. Bump it
+// and append a migration to migrations whenever log_entries,
+// log_entries_meta or meta_keys change shape.
+const currentDatabaseVersion = 2
+
+// metaKeyDBVersion is the key under which the schema version is stored in
+// the metadata table.
+const metaKeyDBVersion = "db_version"
+
+// migration is a single, ordered schema change applied inside the
+// transaction managed by runMigrations.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sqlx.Tx) error
+}
+
+// migrations is the ordered list of schema changes, applied from the
+// database's current db_version up to currentDatabaseVersion.
+//
+// The remaining TODO on SQLiteStore (promoting string-named meta keys to
+// meta_key IDs) is itself a migration once there is data to migrate: add it
+// here as migration{version: N+1, ...} rather than as a one-off function.
+// Promoted columns are not a numbered migration: runMigrations adds them
+// through ensurePromotedColumnsTx after the list below has been applied.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create log_entries, log_entries_meta and meta_keys",
+		up: func(tx *sqlx.Tx) error {
+			if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS log_entries (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				date TIMESTAMP NOT NULL,
+				level VARCHAR(255) NOT NULL,
+				session VARCHAR(255)
+			)`); err != nil {
+				return err
+			}
+
+			if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS log_entries_meta (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				log_entry_id INTEGER NOT NULL,
+				type INTEGER NOT NULL,
+				meta_key_id INTEGER,
+				name VARCHAR(255),
+				int_value INTEGER,
+				real_value REAL,
+				text_value TEXT,
+				blob_value BLOB
+			)`); err != nil {
+				return err
+			}
+
+			for _, col := range []string{"log_entry_id", "type", "name"} {
+				q := "CREATE INDEX IF NOT EXISTS log_entries_meta_" + col + "_idx ON log_entries_meta (" + col + ")"
+				if _, err := tx.Exec(q); err != nil {
+					return err
+				}
+			}
+
+			if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS meta_keys (
+				id INTEGER PRIMARY KEY NOT NULL,
+				key VARCHAR(255)
+			)`); err != nil {
+				return err
+			}
+			if _, err := tx.Exec("CREATE UNIQUE INDEX IF NOT EXISTS meta_keys_key_idx ON meta_keys (key)"); err != nil {
+				return err
+			}
+
+			return nil
+		},
+	},
+	{
+		version: 2,
+		name:    "create log_entries_fts FTS5 index over meta text_value, skipping it if the sqlite3 driver wasn't built with FTS5",
+		up: func(tx *sqlx.Tx) error {
+			// github.com/mattn/go-sqlite3 only compiles in FTS5 support when
+			// built with the sqlite_fts5 build tag. Full-text search is an
+			// add-on (see FullText/opFullText in predicate.go), not something
+			// every SQLiteStore needs to start up, so a binary built without
+			// that tag skips creating the index instead of failing every
+			// migration - and therefore every Init() - from here on.
+			//
+			// errSkippedMigration tells runMigrations not to record this
+			// version as applied, so a later binary built with sqlite_fts5
+			// retries it against the same database file instead of finding
+			// db_version already at 2 and never creating the index.
+			if ok, err := fts5Available(tx); err != nil {
+				return err
+			} else if !ok {
+				return errSkippedMigration
+			}
+
+			if _, err := tx.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS log_entries_fts
+				USING fts5(text_value, content='log_entries_meta', content_rowid='id')`); err != nil {
+				return err
+			}
+
+			// Keep the FTS index in sync with log_entries_meta.
+			triggers := []string{
+				`CREATE TRIGGER IF NOT EXISTS log_entries_meta_ai AFTER INSERT ON log_entries_meta BEGIN
+					INSERT INTO log_entries_fts (rowid, text_value) VALUES (new.id, new.text_value);
+				END`,
+				`CREATE TRIGGER IF NOT EXISTS log_entries_meta_ad AFTER DELETE ON log_entries_meta BEGIN
+					INSERT INTO log_entries_fts (log_entries_fts, rowid, text_value) VALUES ('delete', old.id, old.text_value);
+				END`,
+				`CREATE TRIGGER IF NOT EXISTS log_entries_meta_au AFTER UPDATE ON log_entries_meta BEGIN
+					INSERT INTO log_entries_fts (log_entries_fts, rowid, text_value) VALUES ('delete', old.id, old.text_value);
+					INSERT INTO log_entries_fts (rowid, text_value) VALUES (new.id, new.text_value);
+				END`,
+			}
+			for _, trigger := range triggers {
+				if _, err := tx.Exec(trigger); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	},
+}
+
+// errSkippedMigration signals that a migration intentionally did not apply
+// because a required capability (e.g. FTS5) isn't available, as opposed to
+// failing. runMigrations doesn't advance db_version past a migration that
+// returns it, so the migration is retried on the next Init() rather than
+// being recorded as permanently done.
+var errSkippedMigration = errors.New("migration skipped: required capability unavailable")
+
+// fts5Available reports whether the sqlite3 driver tx is running against was
+// compiled with FTS5 support (the sqlite_fts5 build tag on
+// github.com/mattn/go-sqlite3).
+func fts5Available(tx *sqlx.Tx) (bool, error) {
+	var enabled int
+	if err := tx.Get(&enabled, "SELECT sqlite_compileoption_used('ENABLE_FTS5')"); err != nil {
+		return false, err
+	}
+	return enabled != 0, nil
+}
+
+// ensurePromotedColumnsTx ALTER TABLEs log_entries, inside tx, to add any
+// column declared in schema.PromotedColumns that isn't there yet. It's
+// idempotent within a single call (sqlite has no "ADD COLUMN IF NOT
+// EXISTS", so existing columns are looked up via PRAGMA table_info first),
+// and races between calls are closed by running it inside runMigrations'
+// transaction rather than as a bare PRAGMA-then-ALTER step against l.db.
+func ensurePromotedColumnsTx(tx *sqlx.Tx, schema *Schema) error {
+	existing, err := existingColumns(tx, "log_entries")
+	if err != nil {
+		return err
+	}
+
+	for _, col := range schema.PromotedColumns {
+		if existing[col.Name] {
+			continue
+		}
+
+		ddl := "ALTER TABLE log_entries ADD COLUMN " + col.Name + " " + col.Type.sqlType()
+		if _, err := tx.Exec(ddl); err != nil {
+			return errors.Wrapf(err, "adding promoted column %s", col.Name)
+		}
+		if col.Indexed {
+			idx := "CREATE INDEX IF NOT EXISTS log_entries_" + col.Name + "_idx ON log_entries (" + col.Name + ")"
+			if _, err := tx.Exec(idx); err != nil {
+				return errors.Wrapf(err, "indexing promoted column %s", col.Name)
+			}
+		}
+		existing[col.Name] = true
+	}
+
+	return nil
+}
+
+// existingColumns returns the set of column names currently on table, read
+// through q (a *sqlx.DB or *sqlx.Tx).
+func existingColumns(q sqlx.Queryer, table string) (map[string]bool, error) {
+	rows, err := q.Queryx("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sqlx.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	cols := map[string]bool{}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, ctype string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &ctype, &notNull, &dflt, &pk); err != nil {
+			return nil, err
+		}
+		cols[name] = true
+	}
+
+	return cols, nil
+}
+
+// metaGetValue returns the value stored under key in the metadata table, or
+// "" if it isn't set.
+func metaGetValue(tx *sqlx.Tx, key string) (string, error) {
+	var value string
+	err := tx.Get(&value, "SELECT value FROM metadata WHERE key = ?", key)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// metaSetValue upserts key/value into the metadata table.
+func metaSetValue(tx *sqlx.Tx, key string, value string) error {
+	_, err := tx.Exec("INSERT OR REPLACE INTO metadata (key, value) VALUES (?, ?)", key, value)
+	return err
+}
+
+// runMigrations creates the metadata table if necessary, reads the stored
+// db_version and applies every pending migration inside a single
+// transaction, then ensures schema's promoted columns exist (see
+// ensurePromotedColumnsTx) inside that same transaction. Folding the
+// promoted-column ALTERs in here, rather than running them afterwards as a
+// separate PRAGMA-then-ALTER step, is what keeps two concurrent Init()
+// calls against the same database file from both seeing a column missing
+// and racing to add it - the second transaction blocks on SQLite's
+// file-level write lock until the first commits, then sees the column
+// already there. It refuses to run against a database whose stored version
+// is newer than currentDatabaseVersion, since that means a newer binary
+// wrote to it.
+func runMigrations(db *sqlx.DB, schema *Schema) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec(`CREATE TABLE IF NOT EXISTS metadata (
+		key VARCHAR(255) PRIMARY KEY,
+		value TEXT
+	)`); err != nil {
+		return err
+	}
+
+	version := 0
+	var raw string
+	raw, err = metaGetValue(tx, metaKeyDBVersion)
+	if err != nil {
+		return err
+	}
+	if raw != "" {
+		version, err = strconv.Atoi(raw)
+		if err != nil {
+			return errors.Wrap(err, "invalid db_version")
+		}
+	}
+
+	if version > currentDatabaseVersion {
+		err = errors.Errorf("database schema version %d is newer than this binary supports (%d)", version, currentDatabaseVersion)
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		if err = m.up(tx); err != nil {
+			if err == errSkippedMigration {
+				err = nil
+				continue
+			}
+			err = errors.Wrapf(err, "migration %d (%s) failed", m.version, m.name)
+			return err
+		}
+		if err = metaSetValue(tx, metaKeyDBVersion, strconv.Itoa(m.version)); err != nil {
+			return err
+		}
+	}
+
+	if err = ensurePromotedColumnsTx(tx, schema); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
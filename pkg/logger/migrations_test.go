@@ -0,0 +1,190 @@
+package logger
+
+import (
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openTestDB opens an in-memory sqlite3 database for a single test, pinned
+// to one connection since ":memory:" hands out a fresh, empty database per
+// connection.
+func openTestDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func TestRunMigrations_FromFresh(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := runMigrations(db, NewSchema()); err != nil {
+		t.Fatalf("runMigrations: %v", err)
+	}
+
+	tx := db.MustBegin()
+	raw, err := metaGetValue(tx, metaKeyDBVersion)
+	_ = tx.Rollback()
+	if err != nil {
+		t.Fatalf("metaGetValue: %v", err)
+	}
+	if raw == "" {
+		t.Fatalf("expected db_version to be set after a fresh migration run")
+	}
+}
+
+func TestRunMigrations_FromPartial(t *testing.T) {
+	db := openTestDB(t)
+
+	// Apply only the first migration by hand, as if an older binary had
+	// already run against this database.
+	tx := db.MustBegin()
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS metadata (
+		key VARCHAR(255) PRIMARY KEY,
+		value TEXT
+	)`); err != nil {
+		t.Fatalf("create metadata: %v", err)
+	}
+	if err := migrations[0].up(tx); err != nil {
+		t.Fatalf("apply migration 1: %v", err)
+	}
+	if err := metaSetValue(tx, metaKeyDBVersion, "1"); err != nil {
+		t.Fatalf("metaSetValue: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	if err := runMigrations(db, NewSchema()); err != nil {
+		t.Fatalf("runMigrations from partial state: %v", err)
+	}
+
+	tx = db.MustBegin()
+	raw, err := metaGetValue(tx, metaKeyDBVersion)
+	fts5, fts5Err := fts5Available(tx)
+	_ = tx.Rollback()
+	if err != nil {
+		t.Fatalf("metaGetValue: %v", err)
+	}
+	if fts5Err != nil {
+		t.Fatalf("fts5Available: %v", fts5Err)
+	}
+
+	// Migration 2 only advances db_version when it actually ran; a driver
+	// built without FTS5 support leaves it at 1 so a later binary built with
+	// the sqlite_fts5 tag retries it against this same database.
+	wantVersion := "1"
+	if fts5 {
+		wantVersion = "2"
+	}
+	if raw != wantVersion {
+		t.Fatalf("expected db_version %q after applying the remaining migrations (fts5 available: %v), got %q", wantVersion, fts5, raw)
+	}
+}
+
+// TestRunMigrations_RetriesSkippedFTS5MigrationOnNextRun verifies that a
+// migration 2 skipped because FTS5 wasn't available doesn't get recorded as
+// applied, so a subsequent runMigrations call would retry it. This
+// process's sqlite3 driver build is fixed, so with FTS5 compiled in it only
+// checks that db_version reaches 2; without it, it checks that db_version
+// stays at 1 across two runs.
+func TestRunMigrations_RetriesSkippedFTS5MigrationOnNextRun(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := runMigrations(db, NewSchema()); err != nil {
+		t.Fatalf("runMigrations: %v", err)
+	}
+
+	tx := db.MustBegin()
+	fts5, err := fts5Available(tx)
+	raw, verErr := metaGetValue(tx, metaKeyDBVersion)
+	_ = tx.Rollback()
+	if err != nil {
+		t.Fatalf("fts5Available: %v", err)
+	}
+	if verErr != nil {
+		t.Fatalf("metaGetValue: %v", verErr)
+	}
+
+	if fts5 {
+		if raw != "2" {
+			t.Fatalf("expected db_version 2 when FTS5 is available, got %q", raw)
+		}
+		return
+	}
+
+	if raw != "1" {
+		t.Fatalf("expected db_version to stay at 1 when migration 2 is skipped for lack of FTS5, got %q", raw)
+	}
+
+	// Running migrations again (as a later Init() would) must still attempt
+	// migration 2 rather than treating it as permanently done.
+	if err := runMigrations(db, NewSchema()); err != nil {
+		t.Fatalf("second runMigrations: %v", err)
+	}
+	tx = db.MustBegin()
+	raw, err = metaGetValue(tx, metaKeyDBVersion)
+	_ = tx.Rollback()
+	if err != nil {
+		t.Fatalf("metaGetValue: %v", err)
+	}
+	if raw != "1" {
+		t.Fatalf("expected db_version to still be retried at 1, got %q", raw)
+	}
+}
+
+func TestRunMigrations_PromotedColumnsAreIdempotent(t *testing.T) {
+	db := openTestDB(t)
+	schema := &Schema{
+		MetaKeys:        NewMetaKeys(),
+		PromotedColumns: []PromotedColumn{{Name: "user_id", Type: PromotedColumnText}},
+	}
+
+	if err := runMigrations(db, schema); err != nil {
+		t.Fatalf("runMigrations: %v", err)
+	}
+
+	// A second Init() against the same database (e.g. the process restarting)
+	// must not try to re-add a column that's already there.
+	if err := runMigrations(db, schema); err != nil {
+		t.Fatalf("second runMigrations: %v", err)
+	}
+
+	tx := db.MustBegin()
+	cols, err := existingColumns(tx, "log_entries")
+	_ = tx.Rollback()
+	if err != nil {
+		t.Fatalf("existingColumns: %v", err)
+	}
+	if !cols["user_id"] {
+		t.Fatalf("expected promoted column user_id to exist after runMigrations")
+	}
+}
+
+func TestRunMigrations_RefusesNewerThanBinary(t *testing.T) {
+	db := openTestDB(t)
+
+	tx := db.MustBegin()
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS metadata (
+		key VARCHAR(255) PRIMARY KEY,
+		value TEXT
+	)`); err != nil {
+		t.Fatalf("create metadata: %v", err)
+	}
+	if err := metaSetValue(tx, metaKeyDBVersion, "999"); err != nil {
+		t.Fatalf("metaSetValue: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	if err := runMigrations(db, NewSchema()); err == nil {
+		t.Fatalf("expected runMigrations to refuse a db_version newer than currentDatabaseVersion")
+	}
+}
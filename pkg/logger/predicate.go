@@ -0,0 +1,225 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/huandu/go-sqlbuilder"
+)
+
+// PredicateOp is a comparison operator a MetaPredicate leaf applies.
+type PredicateOp string
+
+const (
+	OpEq      PredicateOp = "eq"
+	OpNe      PredicateOp = "ne"
+	OpLt      PredicateOp = "lt"
+	OpLe      PredicateOp = "le"
+	OpGt      PredicateOp = "gt"
+	OpGe      PredicateOp = "ge"
+	OpIn      PredicateOp = "in"
+	OpLike    PredicateOp = "like"
+	OpBetween PredicateOp = "between"
+	OpIsNull  PredicateOp = "is_null"
+
+	opFullText PredicateOp = "full_text"
+)
+
+// MetaPredicate is a node in a filter tree over a log entry's meta fields.
+// A leaf compares the typed value of meta key Key against Value/Values
+// using Op. JSONPath and FullText build specialized leaves. And/Or combine
+// child predicates into a boolean group; exactly one of (a leaf's fields)
+// or (and/or) is set on any given node.
+type MetaPredicate struct {
+	Key    string
+	Op     PredicateOp
+	Value  interface{}
+	Values []interface{}
+
+	jsonPath string
+	query    string
+
+	isAnd bool
+	isOr  bool
+	and   []*MetaPredicate
+	or    []*MetaPredicate
+}
+
+func Eq(key string, value interface{}) *MetaPredicate {
+	return &MetaPredicate{Key: key, Op: OpEq, Value: value}
+}
+
+func Ne(key string, value interface{}) *MetaPredicate {
+	return &MetaPredicate{Key: key, Op: OpNe, Value: value}
+}
+
+func Lt(key string, value interface{}) *MetaPredicate {
+	return &MetaPredicate{Key: key, Op: OpLt, Value: value}
+}
+
+func Le(key string, value interface{}) *MetaPredicate {
+	return &MetaPredicate{Key: key, Op: OpLe, Value: value}
+}
+
+func Gt(key string, value interface{}) *MetaPredicate {
+	return &MetaPredicate{Key: key, Op: OpGt, Value: value}
+}
+
+func Ge(key string, value interface{}) *MetaPredicate {
+	return &MetaPredicate{Key: key, Op: OpGe, Value: value}
+}
+
+func In(key string, values ...interface{}) *MetaPredicate {
+	return &MetaPredicate{Key: key, Op: OpIn, Values: values}
+}
+
+func Like(key string, pattern string) *MetaPredicate {
+	return &MetaPredicate{Key: key, Op: OpLike, Value: pattern}
+}
+
+func Between(key string, lo, hi interface{}) *MetaPredicate {
+	return &MetaPredicate{Key: key, Op: OpBetween, Values: []interface{}{lo, hi}}
+}
+
+func IsNull(key string) *MetaPredicate {
+	return &MetaPredicate{Key: key, Op: OpIsNull}
+}
+
+// JSONPath matches a JSON-typed meta value at path (e.g. "$.user.id")
+// against value using op (one of Eq/Ne/Lt/Le/Gt/Ge). It compiles to
+// SQLite's json_extract(blob_value, path) <op> value.
+func JSONPath(key, path string, op PredicateOp, value interface{}) *MetaPredicate {
+	return &MetaPredicate{Key: key, Op: op, Value: value, jsonPath: path}
+}
+
+// FullText matches query against the FTS5 index kept over meta text_value
+// (see the "create FTS5 index" migration in migrations.go). That migration
+// skips creating the index when the sqlite3 driver wasn't built with FTS5
+// support, so a FullText predicate against such a store fails at query time
+// with "no such table: log_entries_fts" - build with the sqlite_fts5 tag to
+// use it.
+func FullText(query string) *MetaPredicate {
+	return &MetaPredicate{Op: opFullText, query: query}
+}
+
+// And groups predicates with AND. And() with no predicates compiles to the
+// AND identity (true), matching the all-of-nothing semantics of calling it
+// with a dynamically-filtered, possibly-empty slice.
+func And(preds ...*MetaPredicate) *MetaPredicate {
+	return &MetaPredicate{isAnd: true, and: preds}
+}
+
+// Or groups predicates with OR. Or() with no predicates compiles to the OR
+// identity (false), matching the any-of-nothing semantics of calling it
+// with a dynamically-filtered, possibly-empty slice.
+func Or(preds ...*MetaPredicate) *MetaPredicate {
+	return &MetaPredicate{isOr: true, or: preds}
+}
+
+// Apply compiles p into a boolean SQL expression correlated against
+// log_entries.id, registering any values with q so they stay parameterized
+// the way the rest of sqlbuilder's conditions are. When Key names a
+// promoted column (see Schema.PromotedColumns), the predicate reads
+// straight off log_entries instead of joining log_entries_meta.
+//
+// NOTE(manuel) This only targets SQLite's dialect (json_extract, FTS5
+// MATCH). A Postgres/MySQL backend would need its own translation (->>,
+// jsonb_path_query, MATCH ... AGAINST) once BunStore grows predicate
+// support.
+func (p *MetaPredicate) Apply(schema *Schema, q *sqlbuilder.SelectBuilder) string {
+	if p == nil {
+		return ""
+	}
+
+	if p.isAnd {
+		if len(p.and) == 0 {
+			// The AND identity: an empty group matches unconditionally
+			// rather than falling through to be compiled as a bogus leaf
+			// predicate (Key="", Op="").
+			return "1 = 1"
+		}
+		exprs := make([]string, 0, len(p.and))
+		for _, child := range p.and {
+			exprs = append(exprs, child.Apply(schema, q))
+		}
+		return q.And(exprs...)
+	}
+	if p.isOr {
+		if len(p.or) == 0 {
+			// The OR identity: an empty group matches nothing, the
+			// opposite of And's empty-group identity above.
+			return "1 = 0"
+		}
+		exprs := make([]string, 0, len(p.or))
+		for _, child := range p.or {
+			exprs = append(exprs, child.Apply(schema, q))
+		}
+		return q.Or(exprs...)
+	}
+
+	if p.Op == opFullText {
+		return fmt.Sprintf(
+			"log_entries.id IN (SELECT lem.log_entry_id FROM log_entries_meta lem "+
+				"JOIN log_entries_fts fts ON fts.rowid = lem.id WHERE log_entries_fts MATCH %s)",
+			q.Var(p.query),
+		)
+	}
+
+	if col, ok := schema.PromotedColumn(p.Key); ok {
+		if p.jsonPath == "" {
+			return p.compare(q, "log_entries."+col.Name)
+		}
+		// The key was promoted, so its values live in log_entries.<col> now,
+		// not log_entries_meta - json_extract has to read off that column
+		// instead of falling through to the EXISTS(...log_entries_meta...)
+		// branch below, which would silently match nothing.
+		return p.compare(q, fmt.Sprintf("json_extract(log_entries.%s, %s)", col.Name, q.Var(p.jsonPath)))
+	}
+
+	valueExpr := "COALESCE(lem.int_value, lem.real_value, lem.text_value, lem.blob_value)"
+	if p.jsonPath != "" {
+		valueExpr = fmt.Sprintf("json_extract(lem.blob_value, %s)", q.Var(p.jsonPath))
+	}
+	cmp := p.compare(q, valueExpr)
+
+	keyExpr := fmt.Sprintf("(mk.key = %s OR lem.name = %s)", q.Var(p.Key), q.Var(p.Key))
+
+	return fmt.Sprintf(
+		"EXISTS (SELECT 1 FROM log_entries_meta lem LEFT JOIN meta_keys mk ON mk.id = lem.meta_key_id "+
+			"WHERE lem.log_entry_id = log_entries.id AND %s AND %s)",
+		keyExpr, cmp,
+	)
+}
+
+// compare builds the "<valueExpr> <op> <value(s)>" fragment shared by the
+// promoted-column and log_entries_meta code paths.
+func (p *MetaPredicate) compare(q *sqlbuilder.SelectBuilder, valueExpr string) string {
+	switch p.Op {
+	case OpEq:
+		return valueExpr + " = " + q.Var(p.Value)
+	case OpNe:
+		return valueExpr + " != " + q.Var(p.Value)
+	case OpLt:
+		return valueExpr + " < " + q.Var(p.Value)
+	case OpLe:
+		return valueExpr + " <= " + q.Var(p.Value)
+	case OpGt:
+		return valueExpr + " > " + q.Var(p.Value)
+	case OpGe:
+		return valueExpr + " >= " + q.Var(p.Value)
+	case OpLike:
+		return valueExpr + " LIKE " + q.Var(p.Value)
+	case OpIsNull:
+		return valueExpr + " IS NULL"
+	case OpIn:
+		vars := make([]string, len(p.Values))
+		for i, v := range p.Values {
+			vars[i] = q.Var(v)
+		}
+		return valueExpr + " IN (" + strings.Join(vars, ", ") + ")"
+	case OpBetween:
+		return valueExpr + " BETWEEN " + q.Var(p.Values[0]) + " AND " + q.Var(p.Values[1])
+	default:
+		return valueExpr + " = " + q.Var(p.Value)
+	}
+}
@@ -0,0 +1,59 @@
+package logger
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/huandu/go-sqlbuilder"
+)
+
+func schemaWithPromotedJSONColumn() *Schema {
+	schema := NewSchema()
+	schema.PromotedColumns = []PromotedColumn{
+		{Name: "user", Type: PromotedColumnJSON},
+	}
+	return schema
+}
+
+func TestMetaPredicate_JSONPath_PromotedColumn(t *testing.T) {
+	schema := schemaWithPromotedJSONColumn()
+
+	p := JSONPath("user", "$.id", OpEq, "u-1")
+
+	q := sqlbuilder.Select("*").From("log_entries")
+	expr := p.Apply(schema, q)
+
+	if !strings.Contains(expr, "json_extract(log_entries.user") {
+		t.Fatalf("expected JSONPath against a promoted column to read log_entries.user via json_extract, got: %s", expr)
+	}
+	if strings.Contains(expr, "log_entries_meta") {
+		t.Fatalf("JSONPath against a promoted column must not query log_entries_meta, got: %s", expr)
+	}
+}
+
+func TestMetaPredicate_JSONPath_NonPromotedColumn(t *testing.T) {
+	schema := NewSchema()
+
+	p := JSONPath("user", "$.id", OpEq, "u-1")
+
+	q := sqlbuilder.Select("*").From("log_entries")
+	expr := p.Apply(schema, q)
+
+	if !strings.Contains(expr, "log_entries_meta") || !strings.Contains(expr, "json_extract(lem.blob_value") {
+		t.Fatalf("expected JSONPath against a non-promoted key to query log_entries_meta, got: %s", expr)
+	}
+}
+
+func TestMetaPredicate_EmptyAndOrCompileToIdentities(t *testing.T) {
+	schema := NewSchema()
+
+	q := sqlbuilder.Select("*").From("log_entries")
+	if expr := And().Apply(schema, q); expr != "1 = 1" {
+		t.Fatalf("expected And() with no children to compile to the AND identity (true), got: %q", expr)
+	}
+
+	q = sqlbuilder.Select("*").From("log_entries")
+	if expr := Or().Apply(schema, q); expr != "1 = 0" {
+		t.Fatalf("expected Or() with no children to compile to the OR identity (false), got: %q", expr)
+	}
+}
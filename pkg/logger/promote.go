@@ -0,0 +1,67 @@
+package logger
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// PromoteMetaKey migrates an existing meta key from log_entries_meta onto
+// the real log_entries column name is declared as in l.schema's
+// PromotedColumns: it backfills every row that currently carries that key
+// in log_entries_meta and then deletes those meta rows, all inside one
+// transaction. Write/WriteEntries will already route name into its column
+// going forward; this is only needed to catch up rows written before name
+// was promoted.
+func (l *SQLiteStore) PromoteMetaKey(name string) error {
+	col, ok := l.schema.PromotedColumn(name)
+	if !ok {
+		return errors.Errorf("%q is not declared as a promoted column in this store's schema", name)
+	}
+
+	tx, err := l.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	// Ensure the column exists inside this same transaction rather than as
+	// a separate un-gated step - see ensurePromotedColumnsTx.
+	if err = ensurePromotedColumnsTx(tx, l.schema); err != nil {
+		return err
+	}
+
+	matchKey := `(mk.key = ? OR lem.name = ?)`
+
+	backfill := fmt.Sprintf(`UPDATE log_entries
+		SET %s = (
+			SELECT COALESCE(lem.int_value, lem.real_value, lem.text_value, lem.blob_value)
+			FROM log_entries_meta lem
+			LEFT JOIN meta_keys mk ON mk.id = lem.meta_key_id
+			WHERE lem.log_entry_id = log_entries.id AND %s
+			LIMIT 1
+		)
+		WHERE EXISTS (
+			SELECT 1 FROM log_entries_meta lem
+			LEFT JOIN meta_keys mk ON mk.id = lem.meta_key_id
+			WHERE lem.log_entry_id = log_entries.id AND %s
+		)`, col.Name, matchKey, matchKey)
+	if _, err = tx.Exec(l.db.Rebind(backfill), name, name, name, name); err != nil {
+		return errors.Wrapf(err, "backfilling promoted column %s", col.Name)
+	}
+
+	del := `DELETE FROM log_entries_meta WHERE id IN (
+		SELECT lem.id FROM log_entries_meta lem
+		LEFT JOIN meta_keys mk ON mk.id = lem.meta_key_id
+		WHERE ` + matchKey + `
+	)`
+	if _, err = tx.Exec(l.db.Rebind(del), name, name); err != nil {
+		return errors.Wrapf(err, "deleting promoted meta rows for %s", name)
+	}
+
+	return tx.Commit()
+}
@@ -10,8 +10,8 @@ import (
 // MetaKey is used for often used meta keys, to not store the entire string of the key name,
 // but instead only write use an ID. A separate table is used to keep the metakeys.
 type MetaKey struct {
-	Name string
-	ID   int
+	Name string `db:"key" bun:"key"`
+	ID   int    `db:"id" bun:"id,pk"`
 }
 
 // MetaKeys is a collection of MetaKey. It is used to quickly manage
@@ -97,9 +97,11 @@ func (m *MetaKeys) AddWithID(name string, id int) (*MetaKey, error) {
 	return key, nil
 }
 
-// Schema is a set of MetaKeys
+// Schema is a set of MetaKeys, plus the meta keys that have been promoted
+// to real columns on log_entries.
 type Schema struct {
-	MetaKeys *MetaKeys
+	MetaKeys        *MetaKeys
+	PromotedColumns []PromotedColumn
 }
 
 func NewSchema() *Schema {
@@ -108,29 +110,80 @@ func NewSchema() *Schema {
 	}
 }
 
+// PromotedColumnType is the storage type a PromotedColumn uses on
+// log_entries.
+type PromotedColumnType string
+
+const (
+	PromotedColumnReal PromotedColumnType = "real"
+	PromotedColumnText PromotedColumnType = "text"
+	PromotedColumnInt  PromotedColumnType = "int"
+	PromotedColumnBlob PromotedColumnType = "blob"
+	PromotedColumnJSON PromotedColumnType = "json"
+)
+
+// sqlType returns the SQLite column type to declare for t.
+func (t PromotedColumnType) sqlType() string {
+	switch t {
+	case PromotedColumnReal:
+		return "REAL"
+	case PromotedColumnInt:
+		return "INTEGER"
+	case PromotedColumnBlob:
+		return "BLOB"
+	default:
+		return "TEXT"
+	}
+}
+
+// PromotedColumn declares a meta key that should live as a real column on
+// log_entries instead of a row in log_entries_meta, because it's looked up
+// or filtered on often enough that the per-row meta join/insert is
+// wasteful. See LogWriter.Init and PromoteMetaKey.
+type PromotedColumn struct {
+	Name    string
+	Type    PromotedColumnType
+	Indexed bool
+}
+
+// PromotedColumn returns the PromotedColumn declared for meta key name, if
+// any.
+func (s *Schema) PromotedColumn(name string) (PromotedColumn, bool) {
+	for _, col := range s.PromotedColumns {
+		if col.Name == name {
+			return col, true
+		}
+	}
+	return PromotedColumn{}, false
+}
+
 // LogEntry represents a log entry. It contains metadata and other information
 // about the log entry.
+//
+// It carries both sqlx `db` tags (used by SQLiteStore) and bun `bun` tags
+// (used by BunStore) so that the two Store implementations can share a
+// single schema definition instead of hand-maintained CREATE TABLE strings.
 type LogEntry struct {
-	ID      int       `db:"id"`
-	Date    time.Time `db:"date"`
-	Level   string    `db:"level"`
-	Session *string   `db:"session"`
-	Meta    map[string]interface{}
+	ID      int                    `db:"id" bun:"id,pk,autoincrement"`
+	Date    time.Time              `db:"date" bun:"date,notnull"`
+	Level   string                 `db:"level" bun:"level,notnull"`
+	Session *string                `db:"session" bun:"session"`
+	Meta    map[string]interface{} `bun:"-"`
 }
 
 // LogEntryMeta represents metadata for a LogEntry. It contains the type of the
 // metadata and its value.
 type LogEntryMeta struct {
-	ID         int          `db:"id"`
-	LogEntryID int          `db:"log_entry_id"`
-	Type       LogEntryType `db:"type"`
-	Name       *string      `db:"name"`
-	MetaKeyID  *int         `db:"meta_key_id"`
-	IntValue   *int64       `db:"int_value"`
-	RealValue  *float64     `db:"real_value"`
-	TextValue  *string      `db:"text_value"`
-	BlobValue  *[]byte      `db:"blob_value"`
-	MetaKey    *string      `db:"meta_key"`
+	ID         int          `db:"id" bun:"id,pk,autoincrement"`
+	LogEntryID int          `db:"log_entry_id" bun:"log_entry_id,notnull"`
+	Type       LogEntryType `db:"type" bun:"type,notnull"`
+	Name       *string      `db:"name" bun:"name"`
+	MetaKeyID  *int         `db:"meta_key_id" bun:"meta_key_id"`
+	IntValue   *int64       `db:"int_value" bun:"int_value"`
+	RealValue  *float64     `db:"real_value" bun:"real_value"`
+	TextValue  *string      `db:"text_value" bun:"text_value"`
+	BlobValue  *[]byte      `db:"blob_value" bun:"blob_value"`
+	MetaKey    *string      `db:"meta_key" bun:"-"`
 }
 
 // Value retrieves the value of the LogEntryMeta based on its type. It returns an
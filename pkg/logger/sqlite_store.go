@@ -0,0 +1,565 @@
+package logger
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteStore is the original Store implementation, backed by a single
+// sqlite3 file through sqlbuilder/sqlx. It uses SQLite-specific DDL
+// (AUTOINCREMENT) and upsert syntax (INSERT OR REPLACE).
+type SQLiteStore struct {
+	db     *sqlx.DB
+	schema *Schema
+}
+
+var (
+	_ Store       = (*SQLiteStore)(nil)
+	_ BatchWriter = (*SQLiteStore)(nil)
+)
+
+func NewSQLiteStore(db *sqlx.DB, schema *Schema) *SQLiteStore {
+	if schema == nil {
+		schema = NewSchema()
+	}
+	return &SQLiteStore{
+		db:     db,
+		schema: schema,
+	}
+}
+
+func (l *SQLiteStore) Schema() *Schema {
+	return l.schema
+}
+
+func (l *SQLiteStore) Close() error {
+	if l.db != nil {
+		return l.db.Close()
+	}
+	return nil
+}
+
+func (l *SQLiteStore) WriteEntry(level string, session *string, meta map[string]interface{}) error {
+	tx, err := l.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	promoted, deferred, err := splitPromoted(l.schema, meta)
+	if err != nil {
+		return err
+	}
+
+	// Insert the log entry, including any promoted meta fields as columns.
+	//
+	// The date is formatted and bound as a parameter (dateLayout, matching
+	// scanLogEntryRow and the cursor/From/To comparisons in
+	// GetEntriesFilter.Apply) rather than left to SQLite's CURRENT_TIMESTAMP,
+	// which stores "YYYY-MM-DD HH:MM:SS" - a different, non-comparable text
+	// format that breaks keyset pagination and range filtering.
+	logEntryID := 0
+	cols := append([]string{"date", "level", "session"}, promoted.cols...)
+	values := append([]interface{}{time.Now().UTC().Format(dateLayout), level, session}, promoted.values...)
+	q := sqlbuilder.NewInsertBuilder()
+	q.InsertInto("log_entries").
+		Cols(cols...).
+		Values(values...).
+		SQL("RETURNING id")
+	s, args := q.Build()
+	if err = tx.QueryRowx(s, args...).Scan(&logEntryID); err != nil {
+		return err
+	}
+
+	// Serialize whatever wasn't promoted to a column as log entries meta
+	for k, v := range deferred {
+		row, rowErr := buildMetaRow(l.schema, k, v)
+		if rowErr != nil {
+			err = rowErr
+			return err
+		}
+
+		mq := sqlbuilder.NewInsertBuilder()
+		mq.InsertInto("log_entries_meta").
+			Cols("log_entry_id", "type", "name", "meta_key_id", "int_value", "real_value", "text_value", "blob_value").
+			Values(logEntryID, row.Type, row.Name, row.MetaKeyID, row.IntValue, row.RealValue, row.TextValue, row.BlobValue)
+		s, args := mq.Build()
+		if _, err = tx.Exec(s, args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// promotedValues is the cols/values pair to splice into the log_entries
+// INSERT for meta fields that have been promoted to real columns.
+type promotedValues struct {
+	cols   []string
+	values []interface{}
+}
+
+// splitPromoted separates meta into fields that belong in promoted
+// log_entries columns and fields that still belong in log_entries_meta.
+func splitPromoted(schema *Schema, meta map[string]interface{}) (promotedValues, map[string]interface{}, error) {
+	promoted := promotedValues{}
+	deferred := make(map[string]interface{}, len(meta))
+
+	for k, v := range meta {
+		col, ok := schema.PromotedColumn(k)
+		if !ok {
+			deferred[k] = v
+			continue
+		}
+
+		value := v
+		if col.Type == PromotedColumnJSON {
+			b, err := json.Marshal(v)
+			if err != nil {
+				return promotedValues{}, nil, err
+			}
+			value = string(b)
+		}
+		promoted.cols = append(promoted.cols, col.Name)
+		promoted.values = append(promoted.values, value)
+	}
+
+	return promoted, deferred, nil
+}
+
+// metaRowValues holds the log_entries_meta column values derived from a
+// single meta field, independent of which log entry it belongs to.
+type metaRowValues struct {
+	Type      LogEntryType
+	Name      sql.NullString
+	MetaKeyID sql.NullInt32
+	IntValue  sql.NullInt64
+	RealValue sql.NullFloat64
+	TextValue sql.NullString
+	BlobValue sql.NullString
+}
+
+// buildMetaRow turns a meta field into the row it should be stored as,
+// resolving k against schema's meta keys so that known keys are stored by
+// ID rather than by name.
+func buildMetaRow(schema *Schema, k string, v interface{}) (metaRowValues, error) {
+	row := metaRowValues{}
+
+	switch v := v.(type) {
+	case float64:
+		row.RealValue = sql.NullFloat64{Float64: v, Valid: true}
+		row.Type = LogEntryTypeReal
+	case []byte:
+		row.BlobValue = sql.NullString{String: string(v), Valid: true}
+		row.Type = LogEntryTypeBlob
+	case string:
+		row.TextValue = sql.NullString{String: v, Valid: true}
+		row.Type = LogEntryTypeText
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return metaRowValues{}, err
+		}
+		row.BlobValue = sql.NullString{String: string(b), Valid: true}
+		row.Type = LogEntryTypeJSON
+	}
+
+	// NOTE(manuel, 2023-10-22) Honestly this is all preemptive optimization, I actually don't know if this is necessary.
+	// Maybe the app using the logger could instead just give which columns should be used.
+
+	// If we have a metakey for this key, use its id for storage.
+	if metaKey, ok := schema.MetaKeys.Get(k); ok {
+		row.MetaKeyID = sql.NullInt32{Int32: int32(metaKey.ID), Valid: true}
+	} else {
+		row.Name = sql.NullString{String: k, Valid: true}
+	}
+
+	return row, nil
+}
+
+// WriteEntries persists many entries in a single transaction, batching all
+// of their meta fields into one multi-row INSERT INTO log_entries_meta
+// instead of issuing one INSERT per field per line.
+func (l *SQLiteStore) WriteEntries(entries []ParsedEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	tx, err := l.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	mq := sqlbuilder.NewInsertBuilder()
+	mq.InsertInto("log_entries_meta").
+		Cols("log_entry_id", "type", "name", "meta_key_id", "int_value", "real_value", "text_value", "blob_value")
+	hasMeta := false
+
+	for _, entry := range entries {
+		promoted, deferred, splitErr := splitPromoted(l.schema, entry.Meta)
+		if splitErr != nil {
+			err = splitErr
+			return err
+		}
+
+		cols := append([]string{"date", "level", "session"}, promoted.cols...)
+		values := append([]interface{}{time.Now().UTC().Format(dateLayout), entry.Level, entry.Session}, promoted.values...)
+		q := sqlbuilder.NewInsertBuilder()
+		q.InsertInto("log_entries").
+			Cols(cols...).
+			Values(values...).
+			SQL("RETURNING id")
+		s, args := q.Build()
+		logEntryID := 0
+		if err = tx.QueryRowx(s, args...).Scan(&logEntryID); err != nil {
+			return err
+		}
+
+		for k, v := range deferred {
+			row, rowErr := buildMetaRow(l.schema, k, v)
+			if rowErr != nil {
+				err = rowErr
+				return err
+			}
+			mq.Values(logEntryID, row.Type, row.Name, row.MetaKeyID, row.IntValue, row.RealValue, row.TextValue, row.BlobValue)
+			hasMeta = true
+		}
+	}
+
+	if hasMeta {
+		s, args := mq.Build()
+		if _, err = tx.Exec(s, args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scanLogEntryRow builds a LogEntry from a SELECT * FROM log_entries row
+// already decoded into a column-name-keyed map by sqlx's MapScan. A plain
+// rows.StructScan won't do here: once promoted columns are added to
+// log_entries, the result set has columns LogEntry has no struct field
+// for, which StructScan rejects.
+func scanLogEntryRow(row map[string]interface{}) (*LogEntry, error) {
+	entry := &LogEntry{}
+
+	switch id := row["id"].(type) {
+	case int64:
+		entry.ID = int(id)
+	case int:
+		entry.ID = id
+	}
+
+	switch date := row["date"].(type) {
+	case time.Time:
+		entry.Date = date
+	case []byte:
+		t, err := time.Parse(dateLayout, string(date))
+		if err != nil {
+			return nil, err
+		}
+		entry.Date = t
+	case string:
+		t, err := time.Parse(dateLayout, date)
+		if err != nil {
+			return nil, err
+		}
+		entry.Date = t
+	}
+
+	switch level := row["level"].(type) {
+	case string:
+		entry.Level = level
+	case []byte:
+		entry.Level = string(level)
+	}
+
+	if session, ok := row["session"]; ok && session != nil {
+		switch s := session.(type) {
+		case string:
+			entry.Session = &s
+		case []byte:
+			str := string(s)
+			entry.Session = &str
+		}
+	}
+
+	return entry, nil
+}
+
+// coercePromotedValue turns the driver value for a promoted column back
+// into the type the caller put in, undoing the JSON-as-text encoding
+// splitPromoted applies for PromotedColumnJSON.
+func coercePromotedValue(col PromotedColumn, v interface{}) interface{} {
+	if col.Type != PromotedColumnJSON {
+		return v
+	}
+
+	var raw string
+	switch s := v.(type) {
+	case string:
+		raw = s
+	case []byte:
+		raw = string(s)
+	default:
+		return v
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(raw), &decoded); err != nil {
+		return v
+	}
+	return decoded
+}
+
+func (l *SQLiteStore) GetEntries(filter *GetEntriesFilter) ([]*LogEntry, error) {
+	if filter == nil {
+		filter = NewGetEntriesFilter()
+	}
+
+	entries := map[int]*LogEntry{}
+	q := sqlbuilder.Select("*").From("log_entries")
+	filter.Apply(l.schema, q)
+	s2, args := q.Build()
+	s2 = l.db.Rebind(s2)
+	rows, err := l.db.Queryx(s2, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sqlx.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	ids := []interface{}{}
+
+	for rows.Next() {
+		row := map[string]interface{}{}
+		if err := rows.MapScan(row); err != nil {
+			return nil, err
+		}
+
+		entry, err := scanLogEntryRow(row)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, col := range l.schema.PromotedColumns {
+			v, ok := row[col.Name]
+			if !ok || v == nil {
+				continue
+			}
+			if entry.Meta == nil {
+				entry.Meta = map[string]interface{}{}
+			}
+			entry.Meta[col.Name] = coercePromotedValue(col, v)
+		}
+
+		entries[entry.ID] = entry
+		ids = append(ids, entry.ID)
+	}
+
+	sb := sqlbuilder.Select("lem.*, mk.key AS meta_key").
+		From("log_entries_meta lem")
+
+	sb = sb.Where(sb.In("lem.log_entry_id", ids...)).
+		JoinWithOption(sqlbuilder.LeftJoin, "meta_keys mk", "mk.id = lem.meta_key_id")
+
+	s, args := sb.Build()
+	s = l.db.Rebind(s)
+	rows, err = l.db.Queryx(s, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sqlx.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		meta := &LogEntryMeta{}
+		if err := rows.StructScan(meta); err != nil {
+			return nil, err
+		}
+		entry, ok := entries[meta.LogEntryID]
+		if !ok {
+			continue
+		}
+
+		if entry.Meta == nil {
+			entry.Meta = map[string]interface{}{}
+		}
+		v, err := meta.Value()
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			continue
+		}
+		name := ""
+		if meta.Name != nil {
+			name = *meta.Name
+		} else if meta.MetaKey != nil {
+			name = *meta.MetaKey
+		} else {
+			continue
+		}
+		entry.Meta[name] = v
+	}
+
+	ret := []*LogEntry{}
+	for _, entry := range entries {
+		ret = append(ret, entry)
+	}
+
+	// re-sort in memory: the DB already returned rows in the filter's order,
+	// but the map above doesn't preserve it.
+	if filter.Order == OrderDesc {
+		sort.Slice(ret, func(i, j int) bool {
+			return ret[i].ID > ret[j].ID
+		})
+	} else {
+		sort.Slice(ret, func(i, j int) bool {
+			return ret[i].ID < ret[j].ID
+		})
+	}
+
+	return ret, nil
+}
+
+// Init brings the database up to currentDatabaseVersion by running the
+// pending entries of migrations (see migrations.go), then loads the schema
+// (meta keys) back from the database.
+func (l *SQLiteStore) Init() error {
+	if err := runMigrations(l.db, l.schema); err != nil {
+		return err
+	}
+
+	if err := l.saveSchema(); err != nil {
+		return err
+	}
+
+	if err := l.createTypeEnumTable(); err != nil {
+		return err
+	}
+
+	if err := l.loadSchema(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// TODO(manuel, 2023-08-19) Add a migration to upgrade previously non-meta keys to a meta key
+
+// saveSchema stores the schema of the store in the database.
+//
+// NOTE(manuel, 2023-02-06): This is a very naive implementation.
+// It currently blindly overwrites it, but in the future, it will warn
+// if there is a schema mismatch with what is already present.
+func (l *SQLiteStore) saveSchema() error {
+	return l.SaveMetaKeys(l.schema.MetaKeys)
+}
+
+func (l *SQLiteStore) loadSchema() error {
+	keys, err := l.LoadMetaKeys()
+	if err != nil {
+		return err
+	}
+	l.schema.MetaKeys = keys
+	return nil
+}
+
+func (l *SQLiteStore) createTypeEnumTable() error {
+	ctb := sqlbuilder.NewCreateTableBuilder()
+	ctb.CreateTable("type_enum").
+		IfNotExists().
+		Define("type", "VARCHAR(255)", "PRIMARY KEY").
+		Define("seq", "INTEGER", "NOT NULL")
+	if _, err := l.db.Exec(ctb.String()); err != nil {
+		return err
+	}
+
+	// Insert the types using InsertBuilder
+	q := sqlbuilder.NewInsertBuilder()
+	q.InsertInto("type_enum").
+		Cols("type", "seq").
+		Values("real", LogEntryTypeReal).
+		Values("text", LogEntryTypeText).
+		Values("blob", LogEntryTypeBlob).
+		Values("json", LogEntryTypeJSON).
+		SQL("ON CONFLICT (type) DO NOTHING")
+	s, args := q.Build()
+	if _, err := l.db.Exec(s, args...); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (l *SQLiteStore) SaveMetaKeys(keys *MetaKeys) error {
+	// Insert the keys using InsertBuilder
+	if len(keys.Keys) > 0 {
+		q := sqlbuilder.NewInsertBuilder()
+		q.InsertInto("meta_keys").
+			Cols("id", "key")
+		for _, v := range keys.Keys {
+			q.Values(v.ID, v.Name)
+		}
+		s, args := q.Build()
+		// replace INSERT with INSERT OR REPLACE
+		s = strings.Replace(s, "INSERT", "INSERT OR REPLACE", 1)
+		if _, err := l.db.Exec(s, args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *SQLiteStore) LoadMetaKeys() (*MetaKeys, error) {
+	keys := NewMetaKeys()
+
+	s := sqlbuilder.Select("*").From("meta_keys")
+	rows, err := l.db.Query(s.String())
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		var id int
+		var key string
+		err = rows.Scan(&id, &key)
+		if err != nil {
+			return nil, err
+		}
+		_, err = keys.AddWithID(key, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,52 @@
+package logger
+
+// Store is the persistence backend used by a LogWriter. It is responsible
+// for creating (or migrating) its own schema, translating parsed log
+// entries into whatever DDL/upsert dialect the underlying database speaks,
+// and serving them back out through GetEntries.
+//
+// The sqlite3-backed implementation (SQLiteStore) is the original, hand
+// rolled backend. BunStore is an ORM-backed implementation that can target
+// Postgres, MySQL or sqlite through github.com/uptrace/bun, so that
+// deployments that want a shared, centralized log database aren't stuck
+// with a local sqlite file.
+type Store interface {
+	// Init creates the schema required to store log entries, if it doesn't
+	// already exist, and loads the current Schema (meta keys) from the
+	// database.
+	Init() error
+
+	// WriteEntry persists a single log entry along with its meta fields.
+	WriteEntry(level string, session *string, meta map[string]interface{}) error
+
+	// GetEntries returns the log entries matching filter.
+	GetEntries(filter *GetEntriesFilter) ([]*LogEntry, error)
+
+	// SaveMetaKeys persists the current meta key registry to the store.
+	SaveMetaKeys(keys *MetaKeys) error
+
+	// LoadMetaKeys loads the meta key registry from the store.
+	LoadMetaKeys() (*MetaKeys, error)
+
+	// Schema returns the Schema the store was opened with (and keeps
+	// up to date as meta keys are learned).
+	Schema() *Schema
+
+	Close() error
+}
+
+// ParsedEntry is a single log line, already split into the columns a Store
+// cares about. It's the unit LogWriter's buffered mode queues and batches.
+type ParsedEntry struct {
+	Level   string
+	Session *string
+	Meta    map[string]interface{}
+}
+
+// BatchWriter is implemented by stores that can persist many entries in a
+// single round-trip, e.g. via a multi-row INSERT. LogWriter's buffered mode
+// (see WithBatchSize/WithQueueSize) uses it when available, falling back to
+// one WriteEntry call per entry otherwise.
+type BatchWriter interface {
+	WriteEntries(entries []ParsedEntry) error
+}
@@ -0,0 +1,124 @@
+package logger
+
+import (
+	"context"
+	"time"
+)
+
+// defaultPageSize is used by StreamEntries when filter.Limit is unset.
+const defaultPageSize = 100
+
+// StreamEntries pages through store using filter's keyset cursor, pushing
+// entries to the returned channel as each page comes back. It stops and
+// closes both channels once a page comes back short (no more matching
+// rows) or ctx is cancelled. If store.GetEntries returns an error, that
+// error is sent on the returned error channel before both channels close,
+// rather than being dropped as if the stream had simply ended.
+func StreamEntries(ctx context.Context, store Store, filter *GetEntriesFilter) (<-chan *LogEntry, <-chan error, error) {
+	if filter == nil {
+		filter = NewGetEntriesFilter()
+	}
+	pageSize := filter.Limit
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+
+	out := make(chan *LogEntry)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(out)
+		defer close(errs)
+
+		page := *filter
+		page.Limit = pageSize
+
+		for {
+			entries, err := store.GetEntries(&page)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if len(entries) == 0 {
+				return
+			}
+
+			for _, entry := range entries {
+				select {
+				case out <- entry:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			last := entries[len(entries)-1]
+			page.Cursor = EncodeCursor(last.Date, last.ID)
+
+			if len(entries) < pageSize {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+		}
+	}()
+
+	return out, errs, nil
+}
+
+// Tail streams entries matching filter as they're written, polling store on
+// the given interval for rows with id greater than the last one seen. It
+// only terminates when ctx is cancelled. A failed poll doesn't stop the
+// tail - the underlying error might be transient - but is reported on the
+// returned error channel (buffered by one, so a caller not reading it just
+// misses subsequent errors rather than blocking the poll loop).
+func Tail(ctx context.Context, store Store, filter *GetEntriesFilter, interval time.Duration) (<-chan *LogEntry, <-chan error, error) {
+	if filter == nil {
+		filter = NewGetEntriesFilter()
+	}
+
+	out := make(chan *LogEntry)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(out)
+		defer close(errs)
+
+		page := *filter
+		page.Order = OrderAsc
+		page.Cursor = ""
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			entries, err := store.GetEntries(&page)
+			if err != nil {
+				select {
+				case errs <- err:
+				default:
+				}
+			} else {
+				for _, entry := range entries {
+					select {
+					case out <- entry:
+						page.MinID = entry.ID
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return out, errs, nil
+}
@@ -0,0 +1,110 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestSQLiteStore opens an initialized SQLiteStore against a fresh
+// in-memory database.
+func newTestSQLiteStore(t *testing.T) *SQLiteStore {
+	t.Helper()
+	db := openTestDB(t)
+	store := NewSQLiteStore(db, nil)
+	if err := store.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	return store
+}
+
+func TestStreamEntries_PagesPastTheFirstPage(t *testing.T) {
+	store := newTestSQLiteStore(t)
+
+	for i := 0; i < 2; i++ {
+		if err := store.WriteEntry("info", nil, map[string]interface{}{"i": i}); err != nil {
+			t.Fatalf("WriteEntry %d: %v", i, err)
+		}
+	}
+
+	out, errs, err := StreamEntries(context.Background(), store, NewGetEntriesFilter(WithLimit(1)))
+	if err != nil {
+		t.Fatalf("StreamEntries: %v", err)
+	}
+
+	var got []*LogEntry
+	for entry := range out {
+		got = append(got, entry)
+	}
+	if err, ok := <-errs; ok {
+		t.Fatalf("unexpected error from StreamEntries: %v", err)
+	}
+
+	// Both rows are written on the same calendar day, so this only passes if
+	// the cursor comparison is done against the same date format the rows
+	// were actually written in.
+	if len(got) != 2 {
+		t.Fatalf("expected StreamEntries to page through both rows, got %d", len(got))
+	}
+	if got[0].ID == got[1].ID {
+		t.Fatalf("expected two distinct entries, got the same one twice: %+v", got)
+	}
+}
+
+// erroringStore always fails GetEntries, to exercise StreamEntries/Tail's
+// error-reporting path.
+type erroringStore struct {
+	fakeStore
+}
+
+func (e *erroringStore) GetEntries(filter *GetEntriesFilter) ([]*LogEntry, error) {
+	return nil, errors.New("erroringStore: forced failure")
+}
+
+func TestStreamEntries_ReportsGetEntriesError(t *testing.T) {
+	store := &erroringStore{}
+
+	out, errs, err := StreamEntries(context.Background(), store, nil)
+	if err != nil {
+		t.Fatalf("StreamEntries: %v", err)
+	}
+
+	select {
+	case _, ok := <-out:
+		if ok {
+			t.Fatalf("expected no entries from a store that always errors")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for StreamEntries' entry channel to close")
+	}
+
+	select {
+	case err, ok := <-errs:
+		if !ok || err == nil {
+			t.Fatalf("expected StreamEntries to report the GetEntries error, got ok=%v err=%v", ok, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for StreamEntries' error channel")
+	}
+}
+
+func TestTail_ReportsGetEntriesErrorWithoutStopping(t *testing.T) {
+	store := &erroringStore{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, errs, err := Tail(ctx, store, nil, time.Millisecond)
+	if err != nil {
+		t.Fatalf("Tail: %v", err)
+	}
+
+	select {
+	case err, ok := <-errs:
+		if !ok || err == nil {
+			t.Fatalf("expected Tail to report the GetEntries error, got ok=%v err=%v", ok, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for Tail's error channel")
+	}
+}